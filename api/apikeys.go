@@ -0,0 +1,313 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const apiKeyPrefix = "zk_"
+
+// APIKey is a long-lived credential managed by administrators. Only the
+// SHA-256 hash of its secret is stored, since the secret is random and long
+// enough that a slow hash is not needed.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name,omitempty"`
+	SecretHash string     `json:"secretHash"`
+	Scopes     []string   `json:"scopes"`
+	Namespaces []string   `json:"namespaces,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (k *APIKey) isExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && now.After(*k.ExpiresAt)
+}
+
+type apiKeysFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// APIKeys holds the API keys, and persists them to a file whenever they
+// change if a path is given.
+type APIKeys struct {
+	path string
+	keys map[string]APIKey
+	sync.RWMutex
+}
+
+// NewAPIKeys reads the keys stored at path, if it exists.
+func NewAPIKeys(path string) (*APIKeys, error) {
+	a := &APIKeys{path: path, keys: make(map[string]APIKey)}
+	if path == "" {
+		return a, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var parsedFile apiKeysFile
+	if err := json.Unmarshal(contents, &parsedFile); err != nil {
+		return nil, fmt.Errorf("invalid API keys file %v: %v", path, err)
+	}
+	for _, key := range parsedFile.Keys {
+		if key.ID == "" || key.SecretHash == "" {
+			return nil, fmt.Errorf("invalid API keys file %v: every key needs an id and a secret hash", path)
+		}
+		if len(key.Namespaces) == 0 {
+			key.Namespaces = nil
+		}
+		a.keys[key.ID] = key
+	}
+	return a, nil
+}
+
+func (a *APIKeys) list() []APIKey {
+	keys := make([]APIKey, 0, len(a.keys))
+	for _, key := range a.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+func (a *APIKeys) save() error {
+	if a.path == "" {
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(apiKeysFile{Keys: a.list()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(a.path, contents)
+}
+
+// Create generates a new key and returns it along with its secret, which is
+// the only time the secret is available. A key without namespaces can access
+// every namespace.
+func (a *APIKeys) Create(name string, scopes []string, namespaces []string, expiresAt *time.Time) (APIKey, string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return APIKey{}, "", err
+	}
+	id := hex.EncodeToString(buffer[:8])
+	secret := apiKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(buffer[8:])
+
+	key := APIKey{
+		ID:         id,
+		Name:       name,
+		SecretHash: hashAPIKey(secret),
+		Scopes:     scopes,
+		Namespaces: namespaces,
+		CreatedAt:  time.Now().UTC(),
+		ExpiresAt:  expiresAt,
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.keys[id] = key
+	if err := a.save(); err != nil {
+		delete(a.keys, id)
+		return APIKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+func (a *APIKeys) Revoke(id string) (bool, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	key, ok := a.keys[id]
+	if !ok {
+		return false, nil
+	}
+	delete(a.keys, id)
+	if err := a.save(); err != nil {
+		a.keys[id] = key
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *APIKeys) List() []APIKey {
+	a.RLock()
+	defer a.RUnlock()
+	return a.list()
+}
+
+// authenticate finds the key by the ID embedded in the secret, and compares
+// the hashes in constant time.
+func (a *APIKeys) authenticate(secret string) (*principal, error) {
+	parts := strings.SplitN(strings.TrimPrefix(secret, apiKeyPrefix), "_", 2)
+	if !strings.HasPrefix(secret, apiKeyPrefix) || len(parts) != 2 {
+		return nil, fmt.Errorf("invalid API key")
+	}
+
+	a.RLock()
+	key, ok := a.keys[parts[0]]
+	a.RUnlock()
+
+	if !ok || subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(hashAPIKey(secret))) != 1 {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	if key.isExpired(time.Now()) {
+		return nil, fmt.Errorf("API key has expired")
+	}
+	return &principal{subject: "apikey:" + key.ID, scopes: key.Scopes, namespaces: key.Namespaces}, nil
+}
+
+func hashAPIKey(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// apiKeyFromRequest returns the key sent in the X-API-Key header, or in the
+// Authorization header preceded by the string "ApiKey ".
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "ApiKey ") {
+		return strings.TrimPrefix(authHeader, "ApiKey "), true
+	}
+	return "", false
+}
+
+type createAPIKeyBody struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Namespaces []string   `json:"namespaces,omitempty"`
+	TTL        string     `json:"ttl,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+type apiKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	Namespaces []string   `json:"namespaces,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+func newAPIKeyResponse(key APIKey, secret string) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Key:        secret,
+		Scopes:     key.Scopes,
+		Namespaces: key.Namespaces,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+	}
+}
+
+func createAPIKeyHandler(auth *Auth) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var parsedBody createAPIKeyBody
+		err = json.Unmarshal(requestBody, &parsedBody)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(parsedBody.Scopes) == 0 {
+			jsonError(w, "missing scopes", http.StatusBadRequest)
+			return
+		}
+		if err := validateScopes(parsedBody.Scopes); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		expiresAt := parsedBody.ExpiresAt
+		if parsedBody.TTL != "" {
+			if expiresAt != nil {
+				jsonError(w, "ttl and expiresAt cannot both be set", http.StatusBadRequest)
+				return
+			}
+			timeToLive, err := time.ParseDuration(parsedBody.TTL)
+			if err != nil || timeToLive <= 0 {
+				jsonError(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			expirationTime := time.Now().Add(timeToLive).UTC()
+			expiresAt = &expirationTime
+		}
+
+		namespaces := parsedBody.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = nil
+		}
+
+		key, secret, err := auth.APIKeys.Create(parsedBody.Name, parsedBody.Scopes, namespaces, expiresAt)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newAPIKeyResponse(key, secret))
+	}
+}
+
+func getAPIKeysHandler(auth *Auth) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys := []apiKeyResponse{}
+		for _, key := range auth.APIKeys.List() {
+			keys = append(keys, newAPIKeyResponse(key, ""))
+		}
+
+		response := map[string]interface{}{"keys": keys}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func deleteAPIKeyHandler(auth *Auth) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		ok, err := auth.APIKeys.Revoke(id)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			jsonError(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
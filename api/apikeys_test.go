@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateAPIKeyRejectsUnknownScopes(t *testing.T) {
+	keys, err := NewAPIKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := createAPIKeyHandler(&Auth{APIKeys: keys})
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"scopes":["items:read","items:write","cache:admin"]}`, http.StatusCreated},
+		{`{"scopes":["items:read","items:delete"]}`, http.StatusBadRequest},
+		{`{"scopes":["Items:Read"]}`, http.StatusBadRequest},
+		{`{"scopes":[]}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/admin/apikeys/", strings.NewReader(test.body)))
+		if recorder.Code != test.code {
+			t.Errorf("%v: %v; expected %v", test.body, recorder.Code, test.code)
+		}
+	}
+	if created := len(keys.List()); created != 1 {
+		t.Errorf("%v keys created; expected 1", created)
+	}
+}
@@ -2,20 +2,201 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Auth groups the state needed to issue, verify and revoke tokens. When an
+// external issuer is set, the tokens it issued are accepted as well. API keys
+// are accepted in place of tokens. When ClientCertificates is set, requests
+// without a token are authenticated by their verified client certificate
+// instead.
+type Auth struct {
+	Credentials        *Credentials
+	Keyring            *Keyring
+	Denylist           *Denylist
+	ExternalIssuer     *ExternalIssuer
+	APIKeys            *APIKeys
+	Throttle           *Throttle
+	RefreshTokens      *RefreshTokens
+	ClientCertificates bool
+
+	lifetimes TokenLifetimes
+	sync.RWMutex
+}
+
+// TokenLifetimes holds how long access tokens and refresh tokens are valid,
+// and how long before its expiration an access token can be exchanged for a
+// new one. Refresh tokens are not issued when their lifetime is zero.
+type TokenLifetimes struct {
+	AccessToken   time.Duration
+	RefreshWindow time.Duration
+	RefreshToken  time.Duration
+}
+
+func (a *Auth) SetLifetimes(lifetimes TokenLifetimes) {
+	a.Lock()
+	defer a.Unlock()
+	a.lifetimes = lifetimes
+}
+
+func (a *Auth) getLifetimes() TokenLifetimes {
+	a.RLock()
+	defer a.RUnlock()
+	return a.lifetimes
+}
+
+type tokenClaims struct {
+	Scope      string   `json:"scope"`
+	Namespaces []string `json:"ns,omitempty"`
+	Family     string   `json:"fam,omitempty"`
+	Client     bool     `json:"cli,omitempty"`
+	external   bool
+	jwt.RegisteredClaims
+}
+
+func (claims *tokenClaims) principal() *principal {
+	return &principal{subject: claims.Subject, scopes: splitScopes(claims.Scope), namespaces: claims.Namespaces, client: claims.Client}
+}
+
+func (a *Auth) issueToken(p *principal, family string, timeToLive time.Duration) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &tokenClaims{
+		Scope:      joinScopes(p.scopes),
+		Namespaces: p.namespaces,
+		Family:     family,
+		Client:     p.client,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   p.subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(timeToLive)),
+		},
+	}
+	return a.Keyring.sign(claims)
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// issueTokens issues an access token along with a refresh token of the given
+// family, or of a new family if none is given. The access token carries the
+// family, so that it is revoked along with the refresh tokens.
+func (a *Auth) issueTokens(p *principal, family string) (*tokenResponse, error) {
+	lifetimes := a.getLifetimes()
+	response := &tokenResponse{ExpiresIn: int64(lifetimes.AccessToken.Seconds())}
+
+	if lifetimes.RefreshToken != 0 {
+		var err error
+		response.RefreshToken, family, err = a.RefreshTokens.issue(family, p, lifetimes.RefreshToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	response.Token, err = a.issueToken(p, family, lifetimes.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (a *Auth) revokeFamily(family string) error {
+	return a.Denylist.RevokeFamily(family, time.Now().Add(a.getLifetimes().AccessToken))
+}
+
+func writeTokenResponse(w http.ResponseWriter, response *tokenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *Auth) parseToken(tokenString string) (*tokenClaims, error) {
+	var claims *tokenClaims
+	if a.ExternalIssuer != nil && a.ExternalIssuer.issued(tokenString) {
+		var err error
+		if claims, err = a.ExternalIssuer.parseToken(tokenString); err != nil {
+			return nil, err
+		}
+	} else {
+		token, err := jwt.ParseWithClaims(tokenString, &tokenClaims{}, a.Keyring.verificationKey)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		if claims, ok = token.Claims.(*tokenClaims); !ok {
+			return nil, fmt.Errorf("invalid token")
+		}
+	}
+
+	if a.Denylist.isRevoked(claims) {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+	return claims, nil
+}
+
+func (a *Auth) parseBearerToken(r *http.Request) (*tokenClaims, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, fmt.Errorf("invalid authorization header")
+	}
+	return a.parseToken(strings.TrimPrefix(authHeader, "Bearer "))
+}
+
+// authenticateCertificate identifies the request by the common name of its
+// client certificate, which must match one of the clients. The certificate
+// has already been verified against the client CA bundle during the
+// handshake.
+func (a *Auth) authenticateCertificate(r *http.Request) (*principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, fmt.Errorf("invalid authorization header")
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	p, ok := a.Credentials.clientPrincipal(subject)
+	if !ok {
+		return nil, fmt.Errorf("unknown client certificate subject: %v", subject)
+	}
+	return p, nil
+}
+
+var (
+	errTokenWithoutID     = errors.New("token without jti cannot be revoked")
+	errCredentialsRevoked = errors.New("the credentials of the token have been removed or no longer grant its scopes")
+)
+
+func (a *Auth) revokeToken(claims *tokenClaims) error {
+	if claims.ID == "" {
+		return errTokenWithoutID
+	}
+	return a.Denylist.Revoke(claims.ID, claims.ExpiresAt.Time)
+}
+
 type createTokenBody struct {
-	Secret string `json:"secret"`
+	ClientID   string   `json:"client_id,omitempty"`
+	Secret     string   `json:"secret"`
+	Scopes     []string `json:"scopes,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
 }
 
-func createTokenHandler(secret string, key []byte) func(w http.ResponseWriter, r *http.Request) {
+func createTokenHandler(auth *Auth) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		requestBody, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -30,16 +211,37 @@ func createTokenHandler(secret string, key []byte) func(w http.ResponseWriter, r
 			return
 		}
 
-		if parsedBody.Secret != secret {
-			jsonError(w, "invalid secret", http.StatusUnauthorized)
+		address := remoteAddress(r)
+		if wait := auth.Throttle.wait(address, time.Now()); wait > 0 {
+			tooManyRequests(w, wait)
 			return
 		}
 
-		claims := &jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(20 * time.Minute)),
+		granted := auth.Credentials.authenticate(parsedBody.ClientID, parsedBody.Secret)
+		if granted == nil || len(granted.scopes) == 0 {
+			backoff, remaining := auth.Throttle.fail(address, time.Now())
+			invalidSecret(w, backoff, remaining)
+			return
+		}
+		auth.Throttle.succeed(address)
+		setLoggedSubject(r.Context(), granted.subject)
+
+		p := &principal{subject: granted.subject, scopes: granted.scopes, client: granted.client}
+		if len(parsedBody.Scopes) != 0 {
+			for _, scope := range parsedBody.Scopes {
+				if !containsScope(granted.scopes, scope) {
+					jsonError(w, "scope not granted: "+scope, http.StatusForbidden)
+					return
+				}
+			}
+			p.scopes = parsedBody.Scopes
 		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		signedToken, err := token.SignedString(key)
+		if p.namespaces, err = restrictNamespaces(granted.namespaces, parsedBody.Namespaces); err != nil {
+			jsonError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		response, err := auth.issueTokens(p, "")
 		if err != nil {
 			jsonError(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -55,55 +257,189 @@ func createTokenHandler(secret string, key []byte) func(w http.ResponseWriter, r
 		// }
 		// http.SetCookie(w, &cookie)
 
-		response := map[string]string{"token": signedToken}
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeTokenResponse(w, response)
 	}
 }
 
-func refreshTokenHandler(secret string, key []byte) func(w http.ResponseWriter, r *http.Request) {
+type refreshTokenBody struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshTokenHandler exchanges the refresh token given in the request body
+// for a new pair of tokens or, if the body is empty, exchanges the bearer
+// token of the request for a new one shortly before it expires. The new
+// tokens only keep the scopes and namespaces still granted to their client
+// or shared secret.
+func refreshTokenHandler(auth *Auth) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			jsonError(w, "invalid authorization header", http.StatusUnauthorized)
+		requestBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		if len(strings.TrimSpace(string(requestBody))) != 0 {
+			var parsedBody refreshTokenBody
+			err = json.Unmarshal(requestBody, &parsedBody)
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-			return key, nil
-		})
+
+			token, err := auth.RefreshTokens.use(parsedBody.RefreshToken)
+			if errors.Is(err, errRefreshTokenReused) {
+				setLoggedSubject(r.Context(), token.subject)
+				if err := auth.revokeFamily(token.family); err != nil {
+					jsonError(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				jsonError(w, err.Error()+", every token issued along with it has been revoked", http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				jsonError(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			setLoggedSubject(r.Context(), token.subject)
+
+			p := auth.Credentials.regrant(token.principal())
+			if p == nil {
+				jsonError(w, errCredentialsRevoked.Error(), http.StatusUnauthorized)
+				return
+			}
+			response, err := auth.issueTokens(p, token.family)
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeTokenResponse(w, response)
+			return
+		}
+
+		claims, err := auth.parseBearerToken(r)
 		if err != nil {
 			jsonError(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok {
-			if time.Until(claims.ExpiresAt.Time) > 2*time.Minute {
-				jsonError(w, "refresh attempt too early", http.StatusBadRequest)
+		setLoggedSubject(r.Context(), claims.Subject)
+		if claims.external {
+			jsonError(w, "externally issued tokens cannot be refreshed", http.StatusBadRequest)
+			return
+		}
+		lifetimes := auth.getLifetimes()
+		if time.Until(claims.ExpiresAt.Time) > lifetimes.RefreshWindow {
+			jsonError(w, "refresh attempt too early", http.StatusBadRequest)
+			return
+		}
+
+		p := auth.Credentials.regrant(claims.principal())
+		if p == nil {
+			jsonError(w, errCredentialsRevoked.Error(), http.StatusUnauthorized)
+			return
+		}
+		newSignedToken, err := auth.issueToken(p, claims.Family, lifetimes.AccessToken)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		auth.revokeToken(claims)
+
+		writeTokenResponse(w, &tokenResponse{Token: newSignedToken, ExpiresIn: int64(lifetimes.AccessToken.Seconds())})
+	}
+}
+
+type revokeTokenBody struct {
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// revokeTokenHandler revokes the token given in the request body or, if the
+// body is empty, the bearer token of the request itself. A refresh token
+// revokes its whole family.
+func revokeTokenHandler(auth *Auth) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var claims *tokenClaims
+		if len(strings.TrimSpace(string(requestBody))) != 0 {
+			var parsedBody revokeTokenBody
+			err = json.Unmarshal(requestBody, &parsedBody)
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
-			newClaims := &jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(20 * time.Minute)),
+			if parsedBody.RefreshToken != "" {
+				family, err := auth.RefreshTokens.revokeFamily(parsedBody.RefreshToken)
+				if err != nil {
+					jsonError(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				if err := auth.revokeFamily(family); err != nil {
+					jsonError(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
 			}
-			newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims)
-			newSignedToken, err := newToken.SignedString(key)
+			claims, err = auth.parseToken(parsedBody.Token)
+		} else {
+			claims, err = auth.parseBearerToken(r)
+		}
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		setLoggedSubject(r.Context(), claims.Subject)
+		if err := auth.revokeToken(claims); errors.Is(err, errTokenWithoutID) {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type revokeTokensBody struct {
+	IssuedBefore *time.Time `json:"issuedBefore,omitempty"`
+}
+
+// revokeTokensHandler revokes every token issued before the given time, or
+// before now if no time is given.
+func revokeTokensHandler(auth *Auth) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var parsedBody revokeTokensBody
+		if len(strings.TrimSpace(string(requestBody))) != 0 {
+			err = json.Unmarshal(requestBody, &parsedBody)
 			if err != nil {
-				jsonError(w, err.Error(), http.StatusInternalServerError)
+				jsonError(w, err.Error(), http.StatusBadRequest)
 				return
 			}
+		}
 
-			response := map[string]string{"token": newSignedToken}
-			w.WriteHeader(http.StatusOK)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-		} else {
-			jsonError(w, "invalid token", http.StatusUnauthorized)
+		issuedBefore := time.Now()
+		if parsedBody.IssuedBefore != nil {
+			issuedBefore = *parsedBody.IssuedBefore
 		}
+		if err := auth.Denylist.RevokeBefore(issuedBefore); err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/infamous55/go-zestful/cache"
+)
+
+const maxBatchSize = 1000
+
+type keysBody struct {
+	Keys []string `json:"keys"`
+}
+
+type setManyBody struct {
+	Items []createItemBody `json:"items"`
+}
+
+// itemResult reports the outcome of the operation on one key of a batch,
+// using the status code the matching single-item route would have returned.
+type itemResult struct {
+	Key    string      `json:"key"`
+	Status int         `json:"status"`
+	Value  interface{} `json:"value,omitempty"`
+	Tags   []string    `json:"tags,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func readBatch(w http.ResponseWriter, r *http.Request, body interface{}) bool {
+	requestBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	err = json.Unmarshal(requestBody, body)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func checkBatchSize(w http.ResponseWriter, size int) bool {
+	if size == 0 {
+		jsonError(w, "empty batch", http.StatusBadRequest)
+		return false
+	}
+	if size > maxBatchSize {
+		jsonError(w, fmt.Sprintf("batch exceeds %v items", maxBatchSize), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	return true
+}
+
+func writeResults(w http.ResponseWriter, results []itemResult) {
+	response := map[string]interface{}{"results": results}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func getItemsHandler(w http.ResponseWriter, r *http.Request) {
+	cache := getCache(r.Context())
+	if cache == nil {
+		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var parsedBody keysBody
+	if !readBatch(w, r, &parsedBody) || !checkBatchSize(w, len(parsedBody.Keys)) {
+		return
+	}
+
+	items, errs := cache.GetMany(parsedBody.Keys)
+	results := make([]itemResult, len(parsedBody.Keys))
+	for i, key := range parsedBody.Keys {
+		if errs[i] != nil {
+			results[i] = itemResult{Key: key, Status: http.StatusNotFound, Error: errs[i].Error()}
+		} else {
+			results[i] = itemResult{Key: key, Status: http.StatusOK, Value: items[i].Value, Tags: items[i].Tags}
+		}
+	}
+	writeResults(w, results)
+}
+
+// setItemsHandler creates or replaces every valid item of the batch. Items
+// with an invalid key, value or time-to-live are reported without being
+// passed to the cache.
+func setItemsHandler(w http.ResponseWriter, r *http.Request) {
+	c := getCache(r.Context())
+	if c == nil {
+		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var parsedBody setManyBody
+	if !readBatch(w, r, &parsedBody) || !checkBatchSize(w, len(parsedBody.Items)) {
+		return
+	}
+
+	results := make([]itemResult, len(parsedBody.Items))
+	items := make([]cache.Item, 0, len(parsedBody.Items))
+	indexes := make([]int, 0, len(parsedBody.Items))
+	for i, newItem := range parsedBody.Items {
+		results[i] = itemResult{Key: newItem.Key, Status: http.StatusBadRequest}
+		switch {
+		case newItem.Key == "":
+			results[i].Error = "invalid key"
+			continue
+		case newItem.Value == nil:
+			results[i].Error = "invalid value"
+			continue
+		}
+
+		tags, err := parseTags(newItem.Tags)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		item := cache.Item{Key: newItem.Key, Value: newItem.Value, Tags: tags}
+		if newItem.TimeToLive != nil {
+			ttl, err := time.ParseDuration(*newItem.TimeToLive)
+			if err != nil {
+				results[i].Error = "invalid time-to-live"
+				continue
+			}
+			item.TimeToLive = ttl
+		}
+		items = append(items, item)
+		indexes = append(indexes, i)
+	}
+
+	errs := c.SetMany(items)
+	for j, i := range indexes {
+		if errs[j] != nil {
+			results[i] = itemResult{Key: items[j].Key, Status: setErrorStatus(errs[j]), Error: errs[j].Error()}
+		} else {
+			results[i] = itemResult{Key: items[j].Key, Status: http.StatusNoContent}
+		}
+	}
+	writeResults(w, results)
+}
+
+func deleteItemsHandler(w http.ResponseWriter, r *http.Request) {
+	cache := getCache(r.Context())
+	if cache == nil {
+		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var parsedBody keysBody
+	if !readBatch(w, r, &parsedBody) || !checkBatchSize(w, len(parsedBody.Keys)) {
+		return
+	}
+
+	errs := cache.DeleteMany(parsedBody.Keys)
+	results := make([]itemResult, len(parsedBody.Keys))
+	for i, key := range parsedBody.Keys {
+		if errs[i] != nil {
+			results[i] = itemResult{Key: key, Status: http.StatusNotFound, Error: errs[i].Error()}
+		} else {
+			results[i] = itemResult{Key: key, Status: http.StatusNoContent}
+		}
+	}
+	writeResults(w, results)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/infamous55/go-zestful/cache"
+)
+
+// batchStatuses returns the status reported for each key of a batch response.
+func batchStatuses(t *testing.T, response string) []int {
+	t.Helper()
+	var parsed struct {
+		Results []itemResult `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(response), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	statuses := make([]int, len(parsed.Results))
+	for i, result := range parsed.Results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+func TestSetItemsHandlerReportsEachItem(t *testing.T) {
+	c, _ := cache.New(10, 2048, cache.LRU, 0)
+	body := fmt.Sprintf(`{"items": [
+		{"key": "a", "value": 1},
+		{"key": "", "value": 2},
+		{"key": "b"},
+		{"key": "c", "value": 3, "ttl": "soon"},
+		{"key": "d", "value": 4, "tags": [""]},
+		{"key": "e", "value": %q},
+		{"key": "f", "value": 5, "ttl": "1h", "tags": ["t"]}
+	]}`, strings.Repeat("x", 4096))
+
+	recorder := serveWithCache(setItemsHandler, c, http.MethodPost, "/items/batch/", strings.NewReader(body))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %v (%v); expected %v", recorder.Code, recorder.Body, http.StatusOK)
+	}
+	expected := []int{
+		http.StatusNoContent, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest,
+		http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusNoContent,
+	}
+	if statuses := batchStatuses(t, recorder.Body.String()); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("statuses %v; expected %v", statuses, expected)
+	}
+
+	for key, stored := range map[string]bool{"a": true, "b": false, "c": false, "d": false, "e": false, "f": true} {
+		if _, err := c.Get(key); (err == nil) != stored {
+			t.Errorf("%v stored = %v; expected %v", key, err == nil, stored)
+		}
+	}
+}
+
+func TestGetAndDeleteItemsHandlersReportEachKey(t *testing.T) {
+	c, _ := cache.New(10, 0, cache.LRU, 0)
+	c.Set("a", "a")
+	c.Set("c", "c")
+	body := `{"keys": ["a", "b", "c", "a"]}`
+
+	recorder := serveWithCache(getItemsHandler, c, http.MethodPost, "/items/batch/get/", strings.NewReader(body))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("get: status %v (%v); expected %v", recorder.Code, recorder.Body, http.StatusOK)
+	}
+	expected := []int{http.StatusOK, http.StatusNotFound, http.StatusOK, http.StatusOK}
+	if statuses := batchStatuses(t, recorder.Body.String()); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("get: statuses %v; expected %v", statuses, expected)
+	}
+
+	recorder = serveWithCache(deleteItemsHandler, c, http.MethodDelete, "/items/batch/", strings.NewReader(body))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("delete: status %v (%v); expected %v", recorder.Code, recorder.Body, http.StatusOK)
+	}
+	expected = []int{http.StatusNoContent, http.StatusNotFound, http.StatusNoContent, http.StatusNotFound}
+	if statuses := batchStatuses(t, recorder.Body.String()); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("delete: statuses %v; expected %v", statuses, expected)
+	}
+}
+
+func TestBatchHandlersRejectInvalidBatches(t *testing.T) {
+	keys := make([]string, maxBatchSize+1)
+	items := make([]createItemBody, maxBatchSize+1)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+		items[i] = createItemBody{Key: keys[i], Value: i}
+	}
+	tooManyKeys, _ := json.Marshal(keysBody{Keys: keys})
+	tooManyItems, _ := json.Marshal(setManyBody{Items: items})
+	maxKeys, _ := json.Marshal(keysBody{Keys: keys[:maxBatchSize]})
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		code    int
+	}{
+		{"set without items", setItemsHandler, `{"items": []}`, http.StatusBadRequest},
+		{"get without keys", getItemsHandler, `{}`, http.StatusBadRequest},
+		{"delete without keys", deleteItemsHandler, `{"keys": []}`, http.StatusBadRequest},
+		{"set with too many items", setItemsHandler, string(tooManyItems), http.StatusRequestEntityTooLarge},
+		{"get with too many keys", getItemsHandler, string(tooManyKeys), http.StatusRequestEntityTooLarge},
+		{"delete with too many keys", deleteItemsHandler, string(tooManyKeys), http.StatusRequestEntityTooLarge},
+		{"get with the maximum number of keys", getItemsHandler, string(maxKeys), http.StatusOK},
+		{"malformed body", getItemsHandler, `{"keys": "a"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		c, _ := cache.New(10, 0, cache.LRU, 0)
+		recorder := serveWithCache(test.handler, c, http.MethodPost, "/items/batch/", strings.NewReader(test.body))
+		if recorder.Code != test.code {
+			t.Errorf("%v: status %v (%v); expected %v", test.name, recorder.Code, recorder.Body, test.code)
+		}
+		if info, _ := c.Info(); recorder.Code != http.StatusOK && info["size"] != uint64(0) {
+			t.Errorf("%v: rejected batch changed the cache: %v", test.name, info)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keySetRefreshInterval = time.Minute
+
+// ExternalIssuer verifies tokens issued by an identity provider. Its keys are
+// read from a local JWKS file or fetched from a URL, which is discovered
+// through the OpenID Connect configuration of the issuer when not given.
+type ExternalIssuer struct {
+	issuer         string
+	audience       string
+	keySetURL      string
+	scopeClaim     string
+	namespaceClaim string
+	client         *http.Client
+
+	keys        map[string]interface{}
+	lastRefresh time.Time
+	refreshing  chan struct{}
+	sync.RWMutex
+}
+
+func NewExternalIssuer(issuer string, audience string, keySetURL string, scopeClaim string, namespaceClaim string) *ExternalIssuer {
+	return &ExternalIssuer{
+		issuer:         issuer,
+		audience:       audience,
+		keySetURL:      keySetURL,
+		scopeClaim:     scopeClaim,
+		namespaceClaim: namespaceClaim,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		keys:           make(map[string]interface{}),
+	}
+}
+
+func (e *ExternalIssuer) fetch(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return os.ReadFile(strings.TrimPrefix(url, "file://"))
+	}
+
+	response, err := e.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v from %v", response.StatusCode, url)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+func (e *ExternalIssuer) discoverKeySetURL() (string, error) {
+	contents, err := e.fetch(strings.TrimSuffix(e.issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+
+	var configuration struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(contents, &configuration); err != nil {
+		return "", fmt.Errorf("invalid OpenID configuration: %v", err)
+	}
+	if configuration.Issuer != e.issuer {
+		return "", fmt.Errorf("OpenID configuration is for issuer %v instead of %v", configuration.Issuer, e.issuer)
+	}
+	if configuration.JWKSURI == "" {
+		return "", fmt.Errorf("OpenID configuration has no jwks_uri")
+	}
+	return configuration.JWKSURI, nil
+}
+
+// Refresh reloads the keys of the issuer.
+func (e *ExternalIssuer) Refresh() error {
+	e.Lock()
+	e.lastRefresh = time.Now()
+	e.Unlock()
+
+	return e.refresh()
+}
+
+// refresh fetches the key set without holding the lock, which would block
+// every token verification for as long as the issuer takes to answer, and
+// only takes it to swap in the new keys.
+func (e *ExternalIssuer) refresh() error {
+	keySetURL := e.keySetURL
+	if keySetURL == "" {
+		var err error
+		if keySetURL, err = e.discoverKeySetURL(); err != nil {
+			return err
+		}
+	}
+
+	contents, err := e.fetch(keySetURL)
+	if err != nil {
+		return err
+	}
+
+	var keySet jsonWebKeySet
+	if err := json.Unmarshal(contents, &keySet); err != nil {
+		return fmt.Errorf("invalid key set: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[key.KeyID] = publicKey
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	e.keys = keys
+	return nil
+}
+
+// verificationKey looks the key up by its ID, refreshing the key set at most
+// once per interval so that keys rotated by the issuer are picked up. Tokens
+// with an unknown key ID arriving during a refresh wait for it to finish.
+func (e *ExternalIssuer) verificationKey(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+
+	e.RLock()
+	key, ok := e.lookup(keyID)
+	e.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	e.Lock()
+	if key, ok := e.lookup(keyID); ok {
+		e.Unlock()
+		return key, nil
+	}
+	if refreshed := e.refreshing; refreshed != nil {
+		e.Unlock()
+		<-refreshed
+	} else {
+		if time.Since(e.lastRefresh) < keySetRefreshInterval {
+			e.Unlock()
+			return nil, fmt.Errorf("unknown key id: %v", keyID)
+		}
+		refreshed := make(chan struct{})
+		e.refreshing = refreshed
+		e.lastRefresh = time.Now()
+		e.Unlock()
+
+		err := e.refresh()
+		e.Lock()
+		e.refreshing = nil
+		e.Unlock()
+		close(refreshed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.RLock()
+	defer e.RUnlock()
+	if key, ok := e.lookup(keyID); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id: %v", keyID)
+}
+
+// lookup also accepts tokens without a key ID when the issuer publishes a
+// single key.
+func (e *ExternalIssuer) lookup(keyID string) (interface{}, bool) {
+	if keyID == "" && len(e.keys) == 1 {
+		for _, key := range e.keys {
+			return key, true
+		}
+	}
+	key, ok := e.keys[keyID]
+	return key, ok
+}
+
+func (e *ExternalIssuer) issued(tokenString string) bool {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims); err != nil {
+		return false
+	}
+	return claims.Issuer == e.issuer
+}
+
+// stringsClaim reads a claim that is either a space-separated string or an
+// array of strings.
+func stringsClaim(mapClaims jwt.MapClaims, name string) []string {
+	var values []string
+	switch value := mapClaims[name].(type) {
+	case string:
+		values = splitScopes(value)
+	case []interface{}:
+		for _, element := range value {
+			if s, ok := element.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// parseToken verifies the token and maps the scope and namespace claims
+// configured for the issuer to cache scopes and namespaces. Tokens without
+// namespaces can only access the default namespace. The token must be issued
+// for the configured audience, since the issuer signs tokens for other
+// applications with the same keys.
+func (e *ExternalIssuer) parseToken(tokenString string) (*tokenClaims, error) {
+	if e.audience == "" {
+		return nil, fmt.Errorf("no audience configured for external tokens")
+	}
+	options := []jwt.ParserOption{
+		jwt.WithIssuer(e.issuer),
+		jwt.WithAudience(e.audience),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+	}
+
+	mapClaims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, mapClaims, e.verificationKey, options...); err != nil {
+		return nil, err
+	}
+
+	namespaces := stringsClaim(mapClaims, e.namespaceClaim)
+	if len(namespaces) == 0 {
+		namespaces = []string{DefaultNamespace}
+	}
+
+	claims := &tokenClaims{Scope: joinScopes(stringsClaim(mapClaims, e.scopeClaim)), Namespaces: namespaces, external: true}
+	claims.Issuer = e.issuer
+	claims.Subject, _ = mapClaims.GetSubject()
+	claims.ID, _ = mapClaims["jti"].(string)
+	claims.ExpiresAt, _ = mapClaims.GetExpirationTime()
+	claims.IssuedAt, _ = mapClaims.GetIssuedAt()
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("token has no expiration time")
+	}
+	return claims, nil
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "https://issuer.example"
+
+// newTestIssuer returns an issuer reading its keys from a local key set, along
+// with a function signing tokens with the matching private key.
+func newTestIssuer(t *testing.T, audience string) (*ExternalIssuer, func(jwt.MapClaims) string) {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := newJSONWebKey("test", &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := json.Marshal(jsonWebKeySet{Keys: []jsonWebKey{*publicKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, contents, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := NewExternalIssuer(testIssuer, audience, path, "scope", "namespaces")
+	if err := issuer.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		claims["iss"] = testIssuer
+		claims["sub"] = "user"
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	return issuer, sign
+}
+
+func TestExternalTokenRequiresAudience(t *testing.T) {
+	issuer, sign := newTestIssuer(t, "cache")
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		accepted bool
+	}{
+		{"matching audience", jwt.MapClaims{"aud": "cache"}, true},
+		{"matching audience in a list", jwt.MapClaims{"aud": []string{"other", "cache"}}, true},
+		{"other audience", jwt.MapClaims{"aud": "other"}, false},
+		{"no audience", jwt.MapClaims{}, false},
+	}
+	for _, test := range tests {
+		test.claims["scope"] = ScopeItemsRead
+		_, err := issuer.parseToken(sign(test.claims))
+		if accepted := err == nil; accepted != test.accepted {
+			t.Errorf("%v: accepted = %v (%v); expected %v", test.name, accepted, err, test.accepted)
+		}
+	}
+}
+
+func TestExternalTokenRejectedWithoutConfiguredAudience(t *testing.T) {
+	issuer, sign := newTestIssuer(t, "")
+	if _, err := issuer.parseToken(sign(jwt.MapClaims{"aud": "cache", "scope": ScopeItemsRead})); err == nil {
+		t.Errorf("token accepted without a configured audience")
+	}
+}
+
+func TestExternalTokenNamespaces(t *testing.T) {
+	issuer, sign := newTestIssuer(t, "cache")
+
+	tests := []struct {
+		name       string
+		claim      interface{}
+		namespaces []string
+	}{
+		{"no claim", nil, []string{DefaultNamespace}},
+		{"empty claim", []string{}, []string{DefaultNamespace}},
+		{"string claim", "team-a team-b", []string{"team-a", "team-b"}},
+		{"array claim", []string{"team-a"}, []string{"team-a"}},
+	}
+	for _, test := range tests {
+		claims := jwt.MapClaims{"aud": "cache", "scope": ScopeItemsRead}
+		if test.claim != nil {
+			claims["namespaces"] = test.claim
+		}
+		parsed, err := issuer.parseToken(sign(claims))
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		p := parsed.principal()
+		for _, namespace := range []string{DefaultNamespace, "team-a", "team-b", "team-c"} {
+			expected := false
+			for _, granted := range test.namespaces {
+				expected = expected || granted == namespace
+			}
+			if p.canAccess(namespace) != expected {
+				t.Errorf("%v: access to %v = %v; expected %v", test.name, namespace, !expected, expected)
+			}
+		}
+	}
+}
+
+func TestExternalKeysFetchedWithoutLock(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := newJSONWebKey("test", &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := json.Marshal(jsonWebKeySet{Keys: []jsonWebKey{*publicKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stalled := make(chan struct{})
+	release := make(chan struct{})
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			close(stalled)
+			<-release
+		}
+		w.Write(contents)
+	}))
+	defer server.Close()
+
+	issuer := NewExternalIssuer(testIssuer, "cache", server.URL, "scope", "namespaces")
+	if err := issuer.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	issuer.lastRefresh = time.Time{}
+
+	unknown := make(chan error)
+	go func() {
+		_, err := issuer.verificationKey(&jwt.Token{Header: map[string]interface{}{"kid": "rotated"}})
+		unknown <- err
+	}()
+	<-stalled
+
+	known := make(chan error)
+	go func() {
+		_, err := issuer.verificationKey(&jwt.Token{Header: map[string]interface{}{"kid": "test"}})
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		if err != nil {
+			t.Errorf("known key rejected during a refresh: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("known key blocked by the refresh of the key set")
+	}
+
+	close(release)
+	if err := <-unknown; err == nil {
+		t.Error("unknown key id accepted")
+	}
+}
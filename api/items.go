@@ -1,18 +1,23 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/infamous55/go-zestful/cache"
 )
 
 func getItemHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	cache := getCache(ctx)
-	if cache == nil {
+	c := getCache(ctx)
+	if c == nil {
 		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
 		return
 	}
@@ -24,13 +29,16 @@ func getItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	value, err := cache.Get(key)
+	item, err := c.GetItem(key)
 	if err != nil {
 		jsonError(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	response := map[string]interface{}{"value": value}
+	response := map[string]interface{}{"value": item.Value}
+	if len(item.Tags) != 0 {
+		response["tags"] = item.Tags
+	}
 	jsonBytes, err := json.Marshal(response)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -46,12 +54,13 @@ type createItemBody struct {
 	Key        string      `json:"key"`
 	TimeToLive *string     `json:"ttl,omitempty"`
 	Value      interface{} `json:"value"`
+	Tags       []string    `json:"tags,omitempty"`
 }
 
 func createItemHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	cache := getCache(ctx)
-	if cache == nil {
+	c := getCache(ctx)
+	if c == nil {
 		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
 		return
 	}
@@ -79,22 +88,30 @@ func createItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingItem, _ := cache.Get(newItem.Key)
+	tags, err := parseTags(newItem.Tags)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existingItem, _ := c.Get(newItem.Key)
 	if existingItem != nil {
 		jsonError(w, "item already exists", http.StatusConflict)
 		return
 	}
 
-	var ttl time.Duration
+	item := cache.Item{Key: newItem.Key, Value: newItem.Value, Tags: tags}
 	if newItem.TimeToLive != nil {
-		ttl, err = time.ParseDuration(*newItem.TimeToLive)
+		item.TimeToLive, err = time.ParseDuration(*newItem.TimeToLive)
 		if err != nil {
 			jsonError(w, "invalid time-to-live", http.StatusBadRequest)
 			return
 		}
-		cache.Set(newItem.Key, newItem.Value, ttl)
-	} else {
-		cache.Set(newItem.Key, newItem.Value)
+	}
+	err = c.SetItem(item)
+	if err != nil {
+		jsonError(w, err.Error(), setErrorStatus(err))
+		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -103,12 +120,13 @@ func createItemHandler(w http.ResponseWriter, r *http.Request) {
 type updateItemBody struct {
 	TimeToLive *string     `json:"ttl,omitempty"`
 	Value      interface{} `json:"value"`
+	Tags       []string    `json:"tags,omitempty"`
 }
 
 func updateItemHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	cache := getCache(ctx)
-	if cache == nil {
+	c := getCache(ctx)
+	if c == nil {
 		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
 		return
 	}
@@ -120,7 +138,7 @@ func updateItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := cache.Get(key)
+	_, err := c.Get(key)
 	if err != nil {
 		jsonError(w, err.Error(), http.StatusNotFound)
 		return
@@ -144,16 +162,24 @@ func updateItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var ttl time.Duration
+	tags, err := parseTags(updatedItem.Tags)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item := cache.Item{Key: key, Value: updatedItem.Value, Tags: tags}
 	if updatedItem.TimeToLive != nil {
-		ttl, err = time.ParseDuration(*updatedItem.TimeToLive)
+		item.TimeToLive, err = time.ParseDuration(*updatedItem.TimeToLive)
 		if err != nil {
 			jsonError(w, "invalid time-to-live", http.StatusBadRequest)
 			return
 		}
-		cache.Set(key, updatedItem.Value, ttl)
-	} else {
-		cache.Set(key, updatedItem.Value)
+	}
+	err = c.SetItem(item)
+	if err != nil {
+		jsonError(w, err.Error(), setErrorStatus(err))
+		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -182,3 +208,109 @@ func deleteItemHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type keyResponse struct {
+	Key        string    `json:"key"`
+	TimeToLive string    `json:"ttl,omitempty"`
+	Frequency  uint64    `json:"frequency,omitempty"`
+	LastAccess time.Time `json:"lastAccess"`
+}
+
+// listItemsHandler lists the keys starting with the given prefix in
+// lexicographical order. The cursor returned with each page is opaque to
+// clients, and is passed back to get the next page.
+func listItemsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cache := getCache(ctx)
+	if cache == nil {
+		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultListLimit
+	if query.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			jsonError(w, fmt.Sprintf("invalid limit, must be between 1 and %v", maxListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	cursor, err := base64.RawURLEncoding.DecodeString(query.Get("cursor"))
+	if err != nil {
+		jsonError(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	keys, nextCursor, err := cache.Scan(query.Get("prefix"), string(cursor), limit)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{}
+	keyResponses := make([]keyResponse, len(keys))
+	for i, key := range keys {
+		keyResponses[i] = keyResponse{Key: key.Key, Frequency: key.Frequency, LastAccess: key.LastAccess}
+		if key.TimeToLive != 0 {
+			keyResponses[i].TimeToLive = key.TimeToLive.Round(time.Second).String()
+		}
+	}
+	response["keys"] = keyResponses
+	if nextCursor != "" {
+		response["nextCursor"] = base64.RawURLEncoding.EncodeToString([]byte(nextCursor))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// deleteMatchingItemsHandler deletes every item whose key matches the glob
+// pattern given by the match query parameter, or the regular expression
+// given by the regex query parameter, and returns how many were deleted.
+func deleteMatchingItemsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c := getCache(ctx)
+	if c == nil {
+		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	var (
+		pattern *regexp.Regexp
+		err     error
+	)
+	switch {
+	case query.Has("match") == query.Has("regex"):
+		jsonError(w, "either match or regex must be set", http.StatusBadRequest)
+		return
+	case query.Has("match"):
+		pattern, err = cache.CompileGlob(query.Get("match"))
+	default:
+		pattern, err = regexp.Compile(query.Get("regex"))
+	}
+	if err != nil {
+		jsonError(w, "invalid pattern: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := c.DeleteMatching(pattern)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{"deleted": len(keys)}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/infamous55/go-zestful/cache"
+)
+
+// serveWithCache calls handler with a request whose context holds c, as the
+// namespace middleware does, and returns the response.
+func serveWithCache(handler http.HandlerFunc, c cache.Cache, method string, target string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	r = r.WithContext(context.WithValue(r.Context(), cacheKey, c))
+	recorder := httptest.NewRecorder()
+	handler(recorder, r)
+	return recorder
+}
+
+func TestDeleteMatchingItemsHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		code      int
+		deleted   int
+		remaining []string
+	}{
+		{"glob", "?match=user:*", http.StatusOK, 2, []string{"order:1", "user"}},
+		{"glob character class", "?match=user:[!2]", http.StatusOK, 1, []string{"order:1", "user", "user:2"}},
+		{"escaped glob", `?match=user%5C*`, http.StatusOK, 0, []string{"order:1", "user", "user:1", "user:2"}},
+		{"regular expression", "?regex=^user(:1)?$", http.StatusOK, 2, []string{"order:1", "user:2"}},
+		{"regular expression matching part of a key", "?regex=:1", http.StatusOK, 2, []string{"user", "user:2"}},
+		{"invalid regular expression", "?regex=user(", http.StatusBadRequest, 0, []string{"order:1", "user", "user:1", "user:2"}},
+		{"invalid glob", "?match=user:[", http.StatusBadRequest, 0, []string{"order:1", "user", "user:1", "user:2"}},
+		{"no pattern", "", http.StatusBadRequest, 0, []string{"order:1", "user", "user:1", "user:2"}},
+		{"both patterns", "?match=*&regex=.*", http.StatusBadRequest, 0, []string{"order:1", "user", "user:1", "user:2"}},
+	}
+	for _, test := range tests {
+		c, _ := cache.New(10, 0, cache.LRU, 0)
+		for _, key := range []string{"order:1", "user", "user:1", "user:2"} {
+			c.Set(key, key)
+		}
+
+		recorder := serveWithCache(deleteMatchingItemsHandler, c, http.MethodDelete, "/items/"+test.query, nil)
+		if recorder.Code != test.code {
+			t.Errorf("%v: status %v (%v); expected %v", test.name, recorder.Code, recorder.Body, test.code)
+			continue
+		}
+		if recorder.Code == http.StatusOK {
+			var response struct {
+				Deleted int `json:"deleted"`
+			}
+			if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Deleted != test.deleted {
+				t.Errorf("%v: %v items deleted; expected %v", test.name, response.Deleted, test.deleted)
+			}
+		}
+
+		keys, _, _ := c.Scan("", "", 10)
+		remaining := make([]string, 0, len(keys))
+		for _, keyInfo := range keys {
+			remaining = append(remaining, keyInfo.Key)
+		}
+		if !reflect.DeepEqual(remaining, test.remaining) {
+			t.Errorf("%v: %v left; expected %v", test.name, remaining, test.remaining)
+		}
+	}
+}
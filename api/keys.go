@@ -0,0 +1,322 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SigningAlgorithm string
+
+const (
+	HS256 SigningAlgorithm = "HS256"
+	RS256 SigningAlgorithm = "RS256"
+	ES256 SigningAlgorithm = "ES256"
+	EdDSA SigningAlgorithm = "EdDSA"
+)
+
+func (sa *SigningAlgorithm) Set(value string) error {
+	switch value {
+	case "HS256", "RS256", "ES256", "EdDSA":
+		*sa = SigningAlgorithm(value)
+		return nil
+	default:
+		return fmt.Errorf("parse error")
+	}
+}
+
+func (sa *SigningAlgorithm) String() string {
+	return string(*sa)
+}
+
+func (sa SigningAlgorithm) IsSymmetric() bool {
+	return sa == HS256
+}
+
+func (sa SigningAlgorithm) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(string(sa))
+}
+
+// SigningKey is either a shared secret, used with HS256, or a private key,
+// used with the asymmetric algorithms.
+type SigningKey struct {
+	ID         string        `json:"id"`
+	Secret     []byte        `json:"secret"`
+	PrivateKey crypto.Signer `json:"-"`
+}
+
+// NewSigningKey derives the key ID from the secret, so that the same secret
+// always produces tokens with the same kid header.
+func NewSigningKey(secret []byte) SigningKey {
+	hash := sha256.Sum256(secret)
+	return SigningKey{ID: hex.EncodeToString(hash[:8]), Secret: secret}
+}
+
+// NewPrivateSigningKey uses the JWK thumbprint of the public key as the key
+// ID, so that it can be matched against the published key set.
+func NewPrivateSigningKey(privateKey crypto.Signer) (SigningKey, error) {
+	publicKey, err := newJSONWebKey("", privateKey.Public())
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{ID: publicKey.thumbprint(), PrivateKey: privateKey}, nil
+}
+
+func GenerateSigningKey(algorithm SigningAlgorithm) (SigningKey, error) {
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+	switch algorithm {
+	case HS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return SigningKey{}, err
+		}
+		return NewSigningKey(secret), nil
+	case RS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("invalid signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return NewPrivateSigningKey(privateKey)
+}
+
+type keyFile struct {
+	Keys []SigningKey `json:"keys"`
+}
+
+// ReadKeyFile reads the signing keys stored at path: a JSON file of shared
+// secrets for HS256, or a PEM file of private keys otherwise. The first key
+// is the one used to sign new tokens, while the others are only used to
+// verify tokens issued before a rotation.
+func ReadKeyFile(path string, algorithm SigningAlgorithm) ([]SigningKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !algorithm.IsSymmetric() {
+		return parsePrivateKeys(path, contents)
+	}
+
+	var parsedFile keyFile
+	if err := json.Unmarshal(contents, &parsedFile); err != nil {
+		return nil, fmt.Errorf("invalid key file %v: %v", path, err)
+	}
+	if len(parsedFile.Keys) == 0 {
+		return nil, fmt.Errorf("invalid key file %v: no keys", path)
+	}
+	for _, key := range parsedFile.Keys {
+		if key.ID == "" || len(key.Secret) == 0 {
+			return nil, fmt.Errorf("invalid key file %v: every key needs an id and a secret", path)
+		}
+	}
+
+	return parsedFile.Keys, nil
+}
+
+func parsePrivateKeys(path string, contents []byte) ([]SigningKey, error) {
+	var keys []SigningKey
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			break
+		}
+
+		var (
+			parsedKey interface{}
+			err       error
+		)
+		switch block.Type {
+		case "PRIVATE KEY":
+			parsedKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			parsedKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			parsedKey, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file %v: %v", path, err)
+		}
+
+		privateKey, ok := parsedKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("invalid key file %v: unsupported private key", path)
+		}
+		key, err := NewPrivateSigningKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file %v: %v", path, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("invalid key file %v: no private keys", path)
+	}
+	return keys, nil
+}
+
+func WriteKeyFile(path string, keys []SigningKey) error {
+	if len(keys) != 0 && keys[0].PrivateKey != nil {
+		var contents []byte
+		for _, key := range keys {
+			encodedKey, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+			if err != nil {
+				return err
+			}
+			contents = append(contents, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encodedKey})...)
+		}
+		return os.WriteFile(path, contents, 0o600)
+	}
+
+	contents, err := json.MarshalIndent(keyFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0o600)
+}
+
+type verificationKey struct {
+	key       interface{}
+	publicKey *jsonWebKey
+}
+
+type Keyring struct {
+	algorithm        SigningAlgorithm
+	activeKey        SigningKey
+	verificationKeys map[string]verificationKey
+	sync.RWMutex
+}
+
+// NewKeyring returns a keyring holding the given keys, the first one being
+// active.
+func NewKeyring(algorithm SigningAlgorithm, keys []SigningKey) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys")
+	}
+
+	verificationKeys := make(map[string]verificationKey)
+	for _, key := range keys {
+		if algorithm.IsSymmetric() {
+			if len(key.Secret) == 0 {
+				return nil, fmt.Errorf("signing key %v is not a shared secret", key.ID)
+			}
+			verificationKeys[key.ID] = verificationKey{key: key.Secret}
+			continue
+		}
+
+		if !matchesAlgorithm(key.PrivateKey, algorithm) {
+			return nil, fmt.Errorf("signing key %v cannot be used with %v", key.ID, algorithm)
+		}
+		publicKey, err := newJSONWebKey(key.ID, key.PrivateKey.Public())
+		if err != nil {
+			return nil, err
+		}
+		publicKey.Algorithm = string(algorithm)
+		verificationKeys[key.ID] = verificationKey{key: key.PrivateKey.Public(), publicKey: publicKey}
+	}
+
+	return &Keyring{algorithm: algorithm, activeKey: keys[0], verificationKeys: verificationKeys}, nil
+}
+
+// Replace swaps in the keys of another keyring. Building that keyring first
+// lets callers check the keys before changing anything.
+func (k *Keyring) Replace(keyring *Keyring) {
+	keyring.RLock()
+	algorithm, activeKey, verificationKeys := keyring.algorithm, keyring.activeKey, keyring.verificationKeys
+	keyring.RUnlock()
+
+	k.Lock()
+	defer k.Unlock()
+
+	k.algorithm = algorithm
+	k.activeKey = activeKey
+	k.verificationKeys = verificationKeys
+}
+
+func matchesAlgorithm(privateKey crypto.Signer, algorithm SigningAlgorithm) bool {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return algorithm == RS256
+	case *ecdsa.PrivateKey:
+		return algorithm == ES256 && key.Curve == elliptic.P256()
+	case ed25519.PrivateKey:
+		return algorithm == EdDSA
+	default:
+		return false
+	}
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	token := jwt.NewWithClaims(k.algorithm.method(), claims)
+	token.Header["kid"] = k.activeKey.ID
+	if k.algorithm.IsSymmetric() {
+		return token.SignedString(k.activeKey.Secret)
+	}
+	return token.SignedString(k.activeKey.PrivateKey)
+}
+
+// verificationKey only accepts tokens signed with the same family of
+// algorithms as the keyring, so that a public key can never be used as an
+// HMAC secret.
+func (k *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	if reflect.TypeOf(token.Method) != reflect.TypeOf(k.algorithm.method()) {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	keyID, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing key id")
+	}
+
+	key, ok := k.verificationKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %v", keyID)
+	}
+	return key.key, nil
+}
+
+// publicKeys returns the key set that other services can use to verify
+// tokens. It is empty for shared secrets, which must not be published.
+func (k *Keyring) publicKeys() jsonWebKeySet {
+	k.RLock()
+	defer k.RUnlock()
+
+	keySet := jsonWebKeySet{Keys: []jsonWebKey{}}
+	for _, key := range k.verificationKeys {
+		if key.publicKey != nil {
+			keySet.Keys = append(keySet.Keys, *key.publicKey)
+		}
+	}
+	return keySet
+}
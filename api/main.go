@@ -6,20 +6,48 @@ import (
 
 func RegisterItemsHandlers(subrouter *mux.Router) {
 	subrouter.StrictSlash(true)
-	subrouter.HandleFunc("/{key}/", getItemHandler).Methods("GET")
-	subrouter.HandleFunc("/", createItemHandler).Methods("POST")
-	subrouter.HandleFunc("/{key}/", updateItemHandler).Methods("PUT")
-	subrouter.HandleFunc("/{key}/", deleteItemHandler).Methods("DELETE")
+	subrouter.HandleFunc("/{key}/", requireScope(ScopeItemsRead, getItemHandler)).Methods("GET")
+	subrouter.HandleFunc("/", requireScope(ScopeItemsRead, listItemsHandler)).Methods("GET")
+	subrouter.HandleFunc("/", requireScope(ScopeItemsWrite, createItemHandler)).Methods("POST")
+	subrouter.HandleFunc("/", requireScope(ScopeItemsWrite, deleteMatchingItemsHandler)).Methods("DELETE")
+	subrouter.HandleFunc("/_mget/", requireScope(ScopeItemsRead, getItemsHandler)).Methods("POST")
+	subrouter.HandleFunc("/_mset/", requireScope(ScopeItemsWrite, setItemsHandler)).Methods("POST")
+	subrouter.HandleFunc("/_mdelete/", requireScope(ScopeItemsWrite, deleteItemsHandler)).Methods("POST")
+	subrouter.HandleFunc("/{key}/", requireScope(ScopeItemsWrite, updateItemHandler)).Methods("PUT")
+	subrouter.HandleFunc("/{key}/", requireScope(ScopeItemsWrite, deleteItemHandler)).Methods("DELETE")
+}
+
+func RegisterTagsHandlers(subrouter *mux.Router) {
+	subrouter.StrictSlash(true)
+	subrouter.HandleFunc("/{tag}/", requireScope(ScopeItemsWrite, deleteTaggedItemsHandler)).Methods("DELETE")
 }
 
 func RegisterCacheHandlers(subrouter *mux.Router) {
 	subrouter.StrictSlash(true)
-	subrouter.HandleFunc("/", getCacheInfoHandler).Methods("GET")
-	subrouter.HandleFunc("/", purgeCacheHandler).Methods("DELETE")
+	subrouter.HandleFunc("/", requireScope(ScopeItemsRead, getCacheInfoHandler)).Methods("GET")
+	subrouter.HandleFunc("/", requireScope(ScopeCacheAdmin, purgeCacheHandler)).Methods("DELETE")
+}
+
+func RegisterAuthHandlers(subrouter *mux.Router, auth *Auth) {
+	subrouter.StrictSlash(true)
+	subrouter.HandleFunc("/token/", createTokenHandler(auth)).Methods("POST")
+	subrouter.HandleFunc("/refresh/", refreshTokenHandler(auth)).Methods("POST")
+	subrouter.HandleFunc("/revoke/", revokeTokenHandler(auth)).Methods("POST")
 }
 
-func RegisterAuthHandlers(subrouter *mux.Router, secret string, key []byte) {
+func RegisterAdminHandlers(subrouter *mux.Router, manager ConfigManager, auth *Auth, namespaces *Namespaces) {
 	subrouter.StrictSlash(true)
-	subrouter.HandleFunc("/token/", createTokenHandler(secret, key)).Methods("POST")
-	subrouter.HandleFunc("/refresh/", refreshTokenHandler(secret, key)).Methods("POST")
+	subrouter.HandleFunc("/config/", requireAdmin(getConfigHandler(manager))).Methods("GET")
+	subrouter.HandleFunc("/config/", requireAdmin(updateConfigHandler(manager))).Methods("PATCH")
+	subrouter.HandleFunc("/revoke/", requireAdmin(revokeTokensHandler(auth))).Methods("POST")
+	subrouter.HandleFunc("/metrics/", requireAdmin(getMetricsHandler(auth))).Methods("GET")
+	subrouter.HandleFunc("/apikeys/", requireAdmin(getAPIKeysHandler(auth))).Methods("GET")
+	subrouter.HandleFunc("/apikeys/", requireAdmin(createAPIKeyHandler(auth))).Methods("POST")
+	subrouter.HandleFunc("/apikeys/{id}/", requireAdmin(deleteAPIKeyHandler(auth))).Methods("DELETE")
+	subrouter.HandleFunc("/namespaces/", requireAdmin(getNamespacesHandler(namespaces))).Methods("GET")
+	subrouter.HandleFunc("/namespaces/", requireAdmin(createNamespaceHandler(namespaces))).Methods("POST")
+}
+
+func RegisterWellKnownHandlers(subrouter *mux.Router, auth *Auth) {
+	subrouter.HandleFunc("/jwks.json", getKeySetHandler(auth)).Methods("GET")
 }
@@ -2,50 +2,40 @@ package api
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
-	"strings"
 	"time"
-
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/infamous55/go-zestful/cache"
 )
 
-func GenerateCacheMiddleware(cache cache.Cache) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(
-			func(w http.ResponseWriter, r *http.Request) {
-				ctx := context.WithValue(r.Context(), cacheKey, cache)
-				next.ServeHTTP(w, r.WithContext(ctx))
-			},
-		)
-	}
-}
-
-func GenerateAuthMiddleware(key []byte) func(next http.Handler) http.Handler {
+func GenerateAuthMiddleware(auth *Auth) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
-				authHeader := r.Header.Get("Authorization")
-				if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-					jsonError(w, "invalid authorization header", http.StatusUnauthorized)
-					return
-				}
-
-				tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-				_, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-					if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-						return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+				var p *principal
+				if apiKey, ok := apiKeyFromRequest(r); ok {
+					var err error
+					if p, err = auth.APIKeys.authenticate(apiKey); err != nil {
+						jsonError(w, err.Error(), http.StatusUnauthorized)
+						return
+					}
+				} else if auth.ClientCertificates && r.Header.Get("Authorization") == "" {
+					var err error
+					if p, err = auth.authenticateCertificate(r); err != nil {
+						jsonError(w, err.Error(), http.StatusUnauthorized)
+						return
 					}
-					return key, nil
-				})
-				if err != nil {
-					jsonError(w, err.Error(), http.StatusUnauthorized)
-					return
+				} else {
+					claims, err := auth.parseBearerToken(r)
+					if err != nil {
+						jsonError(w, err.Error(), http.StatusUnauthorized)
+						return
+					}
+					p = claims.principal()
 				}
 
-				next.ServeHTTP(w, r)
+				setLoggedSubject(r.Context(), p.subject)
+				ctx := context.WithValue(r.Context(), principalKey, p)
+				next.ServeHTTP(w, r.WithContext(ctx))
 			},
 		)
 	}
@@ -75,17 +65,32 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.wroteHeader = true
 }
 
+// logEntry collects details that are only known to inner handlers, such as
+// the authenticated principal, so that the logging middleware can report them.
+type logEntry struct {
+	subject string
+}
+
+func setLoggedSubject(ctx context.Context, subject string) {
+	if entry, ok := ctx.Value(logEntryKey).(*logEntry); ok && subject != "" {
+		entry.subject = subject
+	}
+}
+
 func GenerateLoggingMiddleware(logger *log.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
 				start := time.Now()
 				wrapped := wrapResponseWriter(w)
-				next.ServeHTTP(wrapped, r)
+				entry := &logEntry{subject: "-"}
+				ctx := context.WithValue(r.Context(), logEntryKey, entry)
+				next.ServeHTTP(wrapped, r.WithContext(ctx))
 				logger.Println(
 					"status", wrapped.status,
 					"method", r.Method,
 					"path", r.URL.EscapedPath(),
+					"principal", entry.subject,
 					"duration", time.Since(start),
 				)
 			})
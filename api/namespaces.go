@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/infamous55/go-zestful/cache"
+)
+
+// DefaultNamespace is the name of the cache configured by the command-line
+// options, which is also served by the routes without a namespace.
+const DefaultNamespace = "default"
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var errNamespaceExists = errors.New("namespace already exists")
+
+// NamespaceConfig holds the settings of a namespace, as stored in the
+// namespaces file.
+type NamespaceConfig struct {
+	Name           string               `json:"name"`
+	Capacity       uint64               `json:"capacity,omitempty"`
+	MaxMemory      uint64               `json:"maxMemory,omitempty"`
+	EvictionPolicy cache.EvictionPolicy `json:"evictionPolicy"`
+	DefaultTtl     string               `json:"defaultTtl"`
+}
+
+func (config NamespaceConfig) validate() (time.Duration, error) {
+	switch {
+	case !namespacePattern.MatchString(config.Name):
+		return 0, fmt.Errorf("invalid namespace name %q: use up to 64 letters, digits, dashes or underscores", config.Name)
+	case config.Name == DefaultNamespace:
+		return 0, fmt.Errorf("namespace %v already exists", config.Name)
+	case config.Capacity == 0 && config.MaxMemory == 0:
+		return 0, fmt.Errorf("missing capacity or maxMemory for namespace %v", config.Name)
+	}
+
+	policy := config.EvictionPolicy
+	if err := policy.Set(string(config.EvictionPolicy)); err != nil {
+		return 0, fmt.Errorf("invalid eviction policy for namespace %v", config.Name)
+	}
+	defaultTtl, err := time.ParseDuration(config.DefaultTtl)
+	if err != nil {
+		return 0, fmt.Errorf("invalid default time-to-live for namespace %v", config.Name)
+	}
+	return defaultTtl, nil
+}
+
+// CacheOpener creates the cache of a namespace, along with whatever keeps it
+// persisted and swept.
+type CacheOpener func(name string, capacity uint64, maxMemory uint64, evictionPolicy cache.EvictionPolicy, defaultTtl time.Duration) (cache.Cache, error)
+
+// CacheCloser stops what the CacheOpener started for the cache of a namespace
+// that is discarded before being served.
+type CacheCloser func(name string) error
+
+type namespacesFile struct {
+	Namespaces []NamespaceConfig `json:"namespaces"`
+}
+
+// Namespaces holds the caches of every namespace, and persists the settings
+// of the namespaces created at runtime to a file if a path is given.
+type Namespaces struct {
+	path     string
+	open     CacheOpener
+	discard  CacheCloser
+	configs  map[string]NamespaceConfig
+	caches   map[string]cache.Cache
+	creating map[string]struct{}
+	sync.RWMutex
+}
+
+// NewNamespaces registers the default cache, and opens the namespaces stored
+// at path if it exists.
+func NewNamespaces(defaultCache cache.Cache, path string, open CacheOpener, discard CacheCloser) (*Namespaces, error) {
+	n := &Namespaces{
+		path:     path,
+		open:     open,
+		discard:  discard,
+		configs:  make(map[string]NamespaceConfig),
+		caches:   map[string]cache.Cache{DefaultNamespace: defaultCache},
+		creating: make(map[string]struct{}),
+	}
+	if path == "" {
+		return n, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return n, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var parsedFile namespacesFile
+	if err := json.Unmarshal(contents, &parsedFile); err != nil {
+		return nil, fmt.Errorf("invalid namespaces file %v: %v", path, err)
+	}
+	for _, config := range parsedFile.Namespaces {
+		if _, ok := n.caches[config.Name]; ok {
+			return nil, fmt.Errorf("invalid namespaces file %v: duplicate namespace %v", path, config.Name)
+		}
+		if err := n.add(config); err != nil {
+			return nil, fmt.Errorf("invalid namespaces file %v: %v", path, err)
+		}
+	}
+	return n, nil
+}
+
+func (n *Namespaces) add(config NamespaceConfig) error {
+	defaultTtl, err := config.validate()
+	if err != nil {
+		return err
+	}
+
+	c, err := n.open(config.Name, config.Capacity, config.MaxMemory, config.EvictionPolicy, defaultTtl)
+	if err != nil {
+		return err
+	}
+	n.configs[config.Name] = config
+	n.caches[config.Name] = c
+	return nil
+}
+
+func (n *Namespaces) save() error {
+	if n.path == "" {
+		return nil
+	}
+
+	configs := make([]NamespaceConfig, 0, len(n.configs))
+	for _, config := range n.configs {
+		configs = append(configs, config)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Name < configs[j].Name
+	})
+
+	contents, err := json.MarshalIndent(namespacesFile{Namespaces: configs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(n.path, contents)
+}
+
+// Create opens the cache of a new namespace. The name is reserved while the
+// cache opens, which can take a while when its journal is replayed, so that
+// the other namespaces stay available and concurrent requests for the same
+// name fail with errNamespaceExists. The namespace is only served once the
+// namespaces file has been written, and its cache is closed otherwise.
+func (n *Namespaces) Create(config NamespaceConfig) error {
+	defaultTtl, err := config.validate()
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	_, exists := n.caches[config.Name]
+	_, creating := n.creating[config.Name]
+	if exists || creating {
+		n.Unlock()
+		return errNamespaceExists
+	}
+	n.creating[config.Name] = struct{}{}
+	n.Unlock()
+
+	c, err := n.open(config.Name, config.Capacity, config.MaxMemory, config.EvictionPolicy, defaultTtl)
+
+	n.Lock()
+	defer n.Unlock()
+
+	delete(n.creating, config.Name)
+	if err != nil {
+		return err
+	}
+	n.configs[config.Name] = config
+	if err := n.save(); err != nil {
+		delete(n.configs, config.Name)
+		return errors.Join(err, n.discard(config.Name))
+	}
+	n.caches[config.Name] = c
+	return nil
+}
+
+func (n *Namespaces) get(name string) (cache.Cache, bool) {
+	n.RLock()
+	defer n.RUnlock()
+
+	c, ok := n.caches[name]
+	return c, ok
+}
+
+func (n *Namespaces) names() []string {
+	n.RLock()
+	defer n.RUnlock()
+
+	names := make([]string, 0, len(n.caches))
+	for name := range n.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GenerateNamespaceMiddleware puts the cache of the namespace named in the
+// route in the request context, or the default cache for routes without a
+// namespace. It has to run after the auth middleware.
+func GenerateNamespaceMiddleware(namespaces *Namespaces) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				name, ok := mux.Vars(r)["namespace"]
+				if !ok {
+					name = DefaultNamespace
+				}
+
+				if p := getPrincipal(r.Context()); p == nil || !p.canAccess(name) {
+					jsonError(w, "namespace not granted: "+name, http.StatusForbidden)
+					return
+				}
+				c, ok := namespaces.get(name)
+				if !ok {
+					jsonError(w, "namespace does not exist", http.StatusNotFound)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), cacheKey, c)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			},
+		)
+	}
+}
+
+func getNamespacesHandler(namespaces *Namespaces) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := make(map[string]interface{})
+		for _, name := range namespaces.names() {
+			c, ok := namespaces.get(name)
+			if !ok {
+				continue
+			}
+			info, err := c.Info()
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			response[name] = info
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"namespaces": response})
+	}
+}
+
+func createNamespaceHandler(namespaces *Namespaces) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var parsedBody NamespaceConfig
+		err = json.Unmarshal(requestBody, &parsedBody)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := parsedBody.validate(); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := namespaces.Create(parsedBody); errors.Is(err, errNamespaceExists) {
+			jsonError(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}
+}
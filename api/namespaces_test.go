@@ -0,0 +1,129 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infamous55/go-zestful/cache"
+)
+
+func discardNothing(name string) error {
+	return nil
+}
+
+func TestCreateNamespaceDoesNotBlockOtherNamespaces(t *testing.T) {
+	opening := make(chan struct{})
+	release := make(chan struct{})
+	open := func(name string, capacity uint64, maxMemory uint64, evictionPolicy cache.EvictionPolicy, defaultTtl time.Duration) (cache.Cache, error) {
+		close(opening)
+		<-release
+		return cache.New(capacity, maxMemory, evictionPolicy, defaultTtl)
+	}
+	defaultCache, _ := cache.New(10, 0, cache.LRU, 0)
+	namespaces, err := NewNamespaces(defaultCache, "", open, discardNothing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := NamespaceConfig{Name: "team-a", Capacity: 10, EvictionPolicy: cache.LRU, DefaultTtl: "0s"}
+	created := make(chan error)
+	go func() {
+		created <- namespaces.Create(config)
+	}()
+	<-opening
+
+	if _, ok := namespaces.get(DefaultNamespace); !ok {
+		t.Error("default namespace missing while another one opens")
+	}
+	if _, ok := namespaces.get(config.Name); ok {
+		t.Error("namespace available before its cache has opened")
+	}
+	if err := namespaces.Create(config); err != errNamespaceExists {
+		t.Errorf("creating a namespace while it opens: %v; expected %v", err, errNamespaceExists)
+	}
+
+	close(release)
+	if err := <-created; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := namespaces.get(config.Name); !ok {
+		t.Error("namespace missing once its cache has opened")
+	}
+	if err := namespaces.Create(config); err != errNamespaceExists {
+		t.Errorf("creating an existing namespace: %v; expected %v", err, errNamespaceExists)
+	}
+}
+
+func TestCreateNamespaceHandlerReportsDuplicates(t *testing.T) {
+	open := func(name string, capacity uint64, maxMemory uint64, evictionPolicy cache.EvictionPolicy, defaultTtl time.Duration) (cache.Cache, error) {
+		return cache.New(capacity, maxMemory, evictionPolicy, defaultTtl)
+	}
+	defaultCache, _ := cache.New(10, 0, cache.LRU, 0)
+	namespaces, err := NewNamespaces(defaultCache, "", open, discardNothing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := createNamespaceHandler(namespaces)
+
+	const requests = 8
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			body := `{"name":"team-a","capacity":10,"evictionPolicy":"LRU","defaultTtl":"0s"}`
+			handler(recorder, httptest.NewRequest(http.MethodPost, "/admin/namespaces/", strings.NewReader(body)))
+			codes <- recorder.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusCreated] != 1 || counts[http.StatusConflict] != requests-1 {
+		t.Errorf("responses to concurrent requests: %v; expected one 201 and %v 409", counts, requests-1)
+	}
+}
+
+func TestCreateNamespaceDiscardsCacheWhenSaveFails(t *testing.T) {
+	open := func(name string, capacity uint64, maxMemory uint64, evictionPolicy cache.EvictionPolicy, defaultTtl time.Duration) (cache.Cache, error) {
+		return cache.New(capacity, maxMemory, evictionPolicy, defaultTtl)
+	}
+	var discarded []string
+	discard := func(name string) error {
+		discarded = append(discarded, name)
+		return nil
+	}
+	defaultCache, _ := cache.New(10, 0, cache.LRU, 0)
+	path := filepath.Join(t.TempDir(), "missing", "namespaces.json")
+	namespaces, err := NewNamespaces(defaultCache, path, open, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := NamespaceConfig{Name: "team-a", Capacity: 10, EvictionPolicy: cache.LRU, DefaultTtl: "0s"}
+	for i := 1; i <= 2; i++ {
+		if err := namespaces.Create(config); err == nil || err == errNamespaceExists {
+			t.Fatalf("creating a namespace that cannot be saved: %v; expected a save error", err)
+		}
+		if _, ok := namespaces.get(config.Name); ok {
+			t.Error("namespace served although it was not saved")
+		}
+		if _, ok := namespaces.configs[config.Name]; ok {
+			t.Error("namespace kept in the configs although it was not saved")
+		}
+		if len(discarded) != i || discarded[i-1] != config.Name {
+			t.Errorf("discarded caches %v; expected the cache of %v to be discarded", discarded, config.Name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+type refreshToken struct {
+	family         string
+	subject        string
+	scopes         []string
+	namespaces     []string
+	client         bool
+	expirationTime time.Time
+	used           bool
+}
+
+func (token *refreshToken) principal() *principal {
+	return &principal{subject: token.subject, scopes: token.scopes, namespaces: token.namespaces, client: token.client}
+}
+
+// RefreshTokens keeps track of the opaque refresh tokens that have been
+// issued, by the hash of their value. Each token can only be used once, and
+// is replaced by a new token of the same family. Used tokens are kept until
+// they expire, so that a token used twice, e.g. because it was stolen,
+// revokes its whole family.
+type RefreshTokens struct {
+	tokens   map[string]*refreshToken
+	families map[string]time.Time
+	sync.Mutex
+}
+
+func NewRefreshTokens() *RefreshTokens {
+	return &RefreshTokens{
+		tokens:   make(map[string]*refreshToken),
+		families: make(map[string]time.Time),
+	}
+}
+
+func hashRefreshToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+// issue creates a refresh token. An empty family starts a new one.
+func (rt *RefreshTokens) issue(family string, p *principal, timeToLive time.Duration) (string, string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(buffer)
+
+	if family == "" {
+		var err error
+		if family, err = newTokenID(); err != nil {
+			return "", "", err
+		}
+	}
+
+	expirationTime := time.Now().Add(timeToLive)
+	rt.Lock()
+	defer rt.Unlock()
+
+	rt.tokens[hashRefreshToken(value)] = &refreshToken{
+		family:         family,
+		subject:        p.subject,
+		scopes:         p.scopes,
+		namespaces:     p.namespaces,
+		client:         p.client,
+		expirationTime: expirationTime,
+	}
+	if expirationTime.After(rt.families[family]) {
+		rt.families[family] = expirationTime
+	}
+	return value, family, nil
+}
+
+// use marks the token as used and returns it. When the token had already
+// been used, its family is revoked and errRefreshTokenReused is returned
+// along with the token, so that the access tokens of the family can be
+// revoked as well.
+func (rt *RefreshTokens) use(value string) (*refreshToken, error) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	token, ok := rt.tokens[hashRefreshToken(value)]
+	if !ok || time.Now().After(token.expirationTime) {
+		return nil, errInvalidRefreshToken
+	}
+	if _, ok := rt.families[token.family]; !ok {
+		return nil, errInvalidRefreshToken
+	}
+	if token.used {
+		delete(rt.families, token.family)
+		return token, errRefreshTokenReused
+	}
+
+	token.used = true
+	return token, nil
+}
+
+// revokeFamily revokes every refresh token of the family of the given token,
+// and returns that family.
+func (rt *RefreshTokens) revokeFamily(value string) (string, error) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	token, ok := rt.tokens[hashRefreshToken(value)]
+	if !ok {
+		return "", errInvalidRefreshToken
+	}
+	delete(rt.families, token.family)
+	return token.family, nil
+}
+
+func (rt *RefreshTokens) DeleteExpired(timeInterval time.Duration) {
+	ticker := time.NewTicker(timeInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		rt.Lock()
+		now := time.Now()
+		for hash, token := range rt.tokens {
+			if now.After(token.expirationTime) {
+				delete(rt.tokens, hash)
+			}
+		}
+		for family, expirationTime := range rt.families {
+			if now.After(expirationTime) {
+				delete(rt.families, family)
+			}
+		}
+		rt.Unlock()
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestClient(t *testing.T, id string, scopes []string, namespaces []string) Client {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Client{ID: id, SecretHash: string(hash), Scopes: scopes, Namespaces: namespaces}
+}
+
+func TestRegrant(t *testing.T) {
+	credentials := NewCredentials(
+		[]ScopedSecret{
+			{Name: "secret", Secret: "s", Scopes: []string{ScopeItemsRead, ScopeItemsWrite}},
+			{Name: "read-secret", Secret: "", Scopes: []string{ScopeItemsRead}},
+		},
+		[]Client{
+			{ID: "a", Scopes: []string{ScopeItemsRead}, Namespaces: []string{"team-a"}},
+			{ID: "secret", Scopes: []string{ScopeCacheAdmin}},
+		},
+	)
+
+	tests := []struct {
+		name     string
+		token    *principal
+		expected *principal
+	}{
+		{
+			"unchanged client",
+			&principal{subject: "a", scopes: []string{ScopeItemsRead}, namespaces: []string{"team-a"}, client: true},
+			&principal{subject: "a", scopes: []string{ScopeItemsRead}, namespaces: []string{"team-a"}, client: true},
+		},
+		{
+			"client lost a scope",
+			&principal{subject: "a", scopes: []string{ScopeItemsRead, ScopeItemsWrite}, namespaces: []string{"team-a"}, client: true},
+			&principal{subject: "a", scopes: []string{ScopeItemsRead}, namespaces: []string{"team-a"}, client: true},
+		},
+		{
+			"client lost a namespace",
+			&principal{subject: "a", scopes: []string{ScopeItemsRead}, namespaces: []string{"team-a", "team-b"}, client: true},
+			&principal{subject: "a", scopes: []string{ScopeItemsRead}, namespaces: []string{"team-a"}, client: true},
+		},
+		{
+			"client restricted to namespaces",
+			&principal{subject: "a", scopes: []string{ScopeItemsRead}, client: true},
+			&principal{subject: "a", scopes: []string{ScopeItemsRead}, namespaces: []string{"team-a"}, client: true},
+		},
+		{
+			"client lost every namespace",
+			&principal{subject: "a", scopes: []string{ScopeItemsRead}, namespaces: []string{"team-b"}, client: true},
+			nil,
+		},
+		{
+			"client lost every scope",
+			&principal{subject: "a", scopes: []string{ScopeItemsWrite}, client: true},
+			nil,
+		},
+		{
+			"removed client",
+			&principal{subject: "b", scopes: []string{ScopeItemsRead}, client: true},
+			nil,
+		},
+		{
+			"shared secret",
+			&principal{subject: "secret", scopes: []string{ScopeItemsWrite, ScopeCacheAdmin}},
+			&principal{subject: "secret", scopes: []string{ScopeItemsWrite}},
+		},
+		{
+			"unset shared secret",
+			&principal{subject: "read-secret", scopes: []string{ScopeItemsRead}},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		if regranted := credentials.regrant(test.token); !reflect.DeepEqual(regranted, test.expected) {
+			t.Errorf("%v: regranted %+v; expected %+v", test.name, regranted, test.expected)
+		}
+	}
+}
+
+func TestRefreshAfterClientChange(t *testing.T) {
+	keyring, err := NewKeyring(HS256, []SigningKey{NewSigningKey([]byte("key"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	denylist, _ := NewDenylist("")
+	auth := &Auth{
+		Credentials:   NewCredentials(nil, []Client{newTestClient(t, "a", []string{ScopeItemsRead, ScopeItemsWrite}, nil)}),
+		Keyring:       keyring,
+		Denylist:      denylist,
+		Throttle:      newTestThrottle(5, time.Minute, 0),
+		RefreshTokens: NewRefreshTokens(),
+	}
+	auth.SetLifetimes(TokenLifetimes{AccessToken: time.Minute, RefreshWindow: time.Minute, RefreshToken: time.Hour})
+
+	request := func(handler http.HandlerFunc, body string, bearer string) (*httptest.ResponseRecorder, *tokenResponse) {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/auth/", strings.NewReader(body))
+		if bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		handler(recorder, r)
+		var response tokenResponse
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder, &response
+	}
+	scopes := func(response *tokenResponse) []string {
+		claims, err := auth.parseToken(response.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return splitScopes(claims.Scope)
+	}
+
+	recorder, issued := request(createTokenHandler(auth), `{"client_id":"a","secret":"secret"}`, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("token request: %v", recorder.Body)
+	}
+
+	auth.Credentials.SetClients([]Client{newTestClient(t, "a", []string{ScopeItemsRead}, nil)})
+
+	recorder, refreshed := request(refreshTokenHandler(auth), `{"refresh_token":"`+issued.RefreshToken+`"}`, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("refresh after a downgrade: %v", recorder.Body)
+	}
+	if scopes := scopes(refreshed); !reflect.DeepEqual(scopes, []string{ScopeItemsRead}) {
+		t.Errorf("scopes after a downgrade: %v; expected only %v", scopes, ScopeItemsRead)
+	}
+
+	auth.Credentials.SetClients(nil)
+
+	if recorder, _ := request(refreshTokenHandler(auth), `{"refresh_token":"`+refreshed.RefreshToken+`"}`, ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token of a removed client: %v; expected 401", recorder.Code)
+	}
+	if recorder, _ := request(refreshTokenHandler(auth), "", refreshed.Token); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("access token of a removed client: %v; expected 401", recorder.Code)
+	}
+}
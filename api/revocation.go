@@ -0,0 +1,160 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Denylist keeps track of revoked tokens and token families until they
+// expire, along with the time before which every token is considered
+// revoked. Since tokens outlive restarts along with the signing keys, the
+// denylist is persisted to a file whenever it changes if a path is given.
+type Denylist struct {
+	path          string
+	tokens        map[string]time.Time
+	families      map[string]time.Time
+	revokedBefore time.Time
+	sync.RWMutex
+}
+
+type denylistFile struct {
+	Tokens        map[string]time.Time `json:"tokens"`
+	Families      map[string]time.Time `json:"families"`
+	RevokedBefore *time.Time           `json:"revokedBefore,omitempty"`
+}
+
+// NewDenylist reads the revocations stored at path, if it exists.
+func NewDenylist(path string) (*Denylist, error) {
+	d := &Denylist{path: path, tokens: make(map[string]time.Time), families: make(map[string]time.Time)}
+	if path == "" {
+		return d, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var parsedFile denylistFile
+	if err := json.Unmarshal(contents, &parsedFile); err != nil {
+		return nil, fmt.Errorf("invalid revocations file %v: %v", path, err)
+	}
+	for tokenID, expirationTime := range parsedFile.Tokens {
+		d.tokens[tokenID] = expirationTime
+	}
+	for family, expirationTime := range parsedFile.Families {
+		d.families[family] = expirationTime
+	}
+	if parsedFile.RevokedBefore != nil {
+		d.revokedBefore = *parsedFile.RevokedBefore
+	}
+	return d, nil
+}
+
+func (d *Denylist) save() error {
+	if d.path == "" {
+		return nil
+	}
+
+	parsedFile := denylistFile{Tokens: d.tokens, Families: d.families}
+	if !d.revokedBefore.IsZero() {
+		parsedFile.RevokedBefore = &d.revokedBefore
+	}
+	contents, err := json.MarshalIndent(parsedFile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(d.path, contents)
+}
+
+// Revoke revokes a single token until it expires. The revocation applies even
+// if it cannot be saved, in which case the error is returned.
+func (d *Denylist) Revoke(tokenID string, expirationTime time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+	d.tokens[tokenID] = expirationTime
+	return d.save()
+}
+
+// RevokeFamily revokes every access token issued along with the refresh
+// tokens of the family, until the last of them expires.
+func (d *Denylist) RevokeFamily(family string, expirationTime time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+	if expirationTime.After(d.families[family]) {
+		d.families[family] = expirationTime
+	}
+	return d.save()
+}
+
+// RevokeBefore revokes every token issued before the given time. Tokens only
+// record the second they were issued in, so every token issued during that
+// second is revoked as well, whether before or after the given time.
+func (d *Denylist) RevokeBefore(issuedBefore time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+	issuedBefore = issuedBefore.Truncate(time.Second)
+	if issuedBefore.After(d.revokedBefore) {
+		d.revokedBefore = issuedBefore
+	}
+	return d.save()
+}
+
+func (d *Denylist) isRevoked(claims *tokenClaims) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	if _, ok := d.tokens[claims.ID]; ok && claims.ID != "" {
+		return true
+	}
+	if _, ok := d.families[claims.Family]; ok && claims.Family != "" {
+		return true
+	}
+	if claims.IssuedAt == nil {
+		return !d.revokedBefore.IsZero()
+	}
+	return !d.revokedBefore.IsZero() && !claims.IssuedAt.Time.After(d.revokedBefore)
+}
+
+func (d *Denylist) DeleteExpired(timeInterval time.Duration) {
+	ticker := time.NewTicker(timeInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		d.Lock()
+		deleted := false
+		for tokenID, expirationTime := range d.tokens {
+			if time.Now().After(expirationTime) {
+				delete(d.tokens, tokenID)
+				deleted = true
+			}
+		}
+		for family, expirationTime := range d.families {
+			if time.Now().After(expirationTime) {
+				delete(d.families, family)
+				deleted = true
+			}
+		}
+		if deleted {
+			d.save()
+		}
+		d.Unlock()
+	}
+}
+
+func newTokenID() (string, error) {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func issuedAt(t time.Time) *tokenClaims {
+	return &tokenClaims{RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(t)}}
+}
+
+func TestRevokeBeforeCoversWholeSecond(t *testing.T) {
+	second := time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC)
+	denylist, _ := NewDenylist("")
+	denylist.RevokeBefore(second.Add(700 * time.Millisecond))
+
+	tests := []struct {
+		issued  time.Time
+		revoked bool
+	}{
+		{second.Add(-time.Second), true},
+		{second.Add(200 * time.Millisecond), true},
+		{second.Add(900 * time.Millisecond), true},
+		{second.Add(time.Second), false},
+	}
+	for _, test := range tests {
+		if revoked := denylist.isRevoked(issuedAt(test.issued)); revoked != test.revoked {
+			t.Errorf("token issued at %v: revoked = %v; expected %v", test.issued.Format(time.StampMilli), revoked, test.revoked)
+		}
+	}
+}
+
+func TestRevokeBeforeNeverMovesBack(t *testing.T) {
+	now := time.Now()
+	denylist, _ := NewDenylist("")
+	denylist.RevokeBefore(now)
+	denylist.RevokeBefore(now.Add(-time.Hour))
+
+	if !denylist.isRevoked(issuedAt(now.Add(-time.Minute))) {
+		t.Errorf("an earlier revocation time replaced a later one")
+	}
+}
+
+func TestDenylistPersistsRevocations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	now := time.Now()
+
+	denylist, err := NewDenylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := denylist.Revoke("token", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := denylist.RevokeFamily("family", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := denylist.RevokeBefore(now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	denylist, err = NewDenylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := jwt.NewNumericDate(now)
+	tests := []struct {
+		claims  *tokenClaims
+		revoked bool
+	}{
+		{&tokenClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "token", IssuedAt: issued}}, true},
+		{&tokenClaims{Family: "family", RegisteredClaims: jwt.RegisteredClaims{ID: "other", IssuedAt: issued}}, true},
+		{issuedAt(now.Add(-2 * time.Hour)), true},
+		{&tokenClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "other", IssuedAt: issued}}, false},
+	}
+	for i, test := range tests {
+		if revoked := denylist.isRevoked(test.claims); revoked != test.revoked {
+			t.Errorf("token %v after a restart: revoked = %v; expected %v", i, revoked, test.revoked)
+		}
+	}
+}
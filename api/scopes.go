@@ -0,0 +1,323 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ScopeItemsRead  = "items:read"
+	ScopeItemsWrite = "items:write"
+	ScopeCacheAdmin = "cache:admin"
+)
+
+// principal is the identity of an authenticated request. Its subject is
+// either the ID of a client or the name of a shared secret. A nil list of
+// namespaces grants access to every namespace.
+type principal struct {
+	subject    string
+	scopes     []string
+	namespaces []string
+	client     bool
+}
+
+func (p *principal) hasScope(scope string) bool {
+	return containsScope(p.scopes, scope)
+}
+
+func (p *principal) canAccess(namespace string) bool {
+	return p.namespaces == nil || containsScope(p.namespaces, namespace)
+}
+
+func getPrincipal(ctx context.Context) *principal {
+	if p, ok := ctx.Value(principalKey).(*principal); ok {
+		return p
+	}
+	return nil
+}
+
+func requireScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := getPrincipal(r.Context())
+		if p == nil || !p.hasScope(scope) {
+			jsonError(w, "missing scope "+scope, http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// requireAdmin allows only principals with the admin scope that are not
+// restricted to some namespaces, since admin routes affect every namespace.
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return requireScope(ScopeCacheAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if getPrincipal(r.Context()).namespaces != nil {
+			jsonError(w, "admin routes require access to every namespace", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// restrictNamespaces returns the namespaces requested out of the granted
+// ones, or the granted ones if none are requested.
+func restrictNamespaces(granted []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+	for _, namespace := range requested {
+		if granted != nil && !containsScope(granted, namespace) {
+			return nil, fmt.Errorf("namespace not granted: %v", namespace)
+		}
+	}
+	return requested, nil
+}
+
+// validateScopes rejects scopes other than the ones above, which would grant
+// nothing and are most likely typos.
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeItemsRead, ScopeItemsWrite, ScopeCacheAdmin:
+		default:
+			return fmt.Errorf("unknown scope %v", scope)
+		}
+	}
+	return nil
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+func splitScopes(scope string) []string {
+	return strings.Fields(scope)
+}
+
+type ScopedSecret struct {
+	Name   string
+	Secret string
+	Scopes []string
+}
+
+type Client struct {
+	ID         string   `json:"id"`
+	SecretHash string   `json:"secretHash"`
+	Scopes     []string `json:"scopes"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+type clientsFile struct {
+	Clients []Client `json:"clients"`
+}
+
+// ReadClientsFile reads the clients stored at path. Their secrets must be
+// hashed with bcrypt, except for clients that only authenticate with a
+// certificate, which have no secret.
+func ReadClientsFile(path string) ([]Client, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsedFile clientsFile
+	if err := json.Unmarshal(contents, &parsedFile); err != nil {
+		return nil, fmt.Errorf("invalid clients file %v: %v", path, err)
+	}
+
+	ids := make(map[string]struct{})
+	for _, client := range parsedFile.Clients {
+		if client.ID == "" {
+			return nil, fmt.Errorf("invalid clients file %v: every client needs an id", path)
+		}
+		if _, ok := ids[client.ID]; ok {
+			return nil, fmt.Errorf("invalid clients file %v: duplicate client %v", path, client.ID)
+		}
+		if err := validateScopes(client.Scopes); err != nil {
+			return nil, fmt.Errorf("invalid clients file %v: %v for client %v", path, err, client.ID)
+		}
+		if _, err := bcrypt.Cost([]byte(client.SecretHash)); client.SecretHash != "" && err != nil {
+			return nil, fmt.Errorf("invalid clients file %v: invalid secret hash for client %v: %v", path, client.ID, err)
+		}
+		ids[client.ID] = struct{}{}
+	}
+
+	for i := range parsedFile.Clients {
+		if len(parsedFile.Clients[i].Namespaces) == 0 {
+			parsedFile.Clients[i].Namespaces = nil
+		}
+	}
+	return parsedFile.Clients, nil
+}
+
+// Credentials holds the shared secrets and the clients that can exchange
+// their secrets for tokens, along with the scopes granted to each of them.
+type Credentials struct {
+	secrets []ScopedSecret
+	clients map[string]Client
+	sync.RWMutex
+}
+
+func NewCredentials(secrets []ScopedSecret, clients []Client) *Credentials {
+	credentials := &Credentials{}
+	credentials.SetSecrets(secrets)
+	credentials.SetClients(clients)
+	return credentials
+}
+
+func (c *Credentials) SetSecrets(secrets []ScopedSecret) {
+	c.Lock()
+	defer c.Unlock()
+	c.secrets = secrets
+}
+
+func (c *Credentials) SetClients(clients []Client) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.clients = make(map[string]Client)
+	for _, client := range clients {
+		c.clients[client.ID] = client
+	}
+}
+
+// authenticate returns the principal matching the given credentials, or nil
+// if they are invalid. Requests without a client ID are matched against the
+// shared secrets, are identified by the name of the secret they used and can
+// access every namespace.
+func (c *Credentials) authenticate(clientID string, secret string) *principal {
+	c.RLock()
+	defer c.RUnlock()
+
+	if clientID != "" {
+		client, ok := c.clients[clientID]
+		if !ok || client.SecretHash == "" {
+			// Unknown clients are compared against a dummy hash, so that they
+			// take as long to reject as wrong secrets.
+			bcrypt.CompareHashAndPassword(dummySecretHash(), []byte(secret))
+			return nil
+		}
+		if bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) != nil {
+			return nil
+		}
+		return client.principal()
+	}
+
+	var (
+		subject string
+		scopes  []string
+	)
+	for _, scopedSecret := range c.secrets {
+		if scopedSecret.Secret != "" && subtle.ConstantTimeCompare([]byte(scopedSecret.Secret), []byte(secret)) == 1 {
+			if subject == "" {
+				subject = scopedSecret.Name
+			}
+			for _, scope := range scopedSecret.Scopes {
+				if !containsScope(scopes, scope) {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	return &principal{subject: subject, scopes: scopes}
+}
+
+func (client Client) principal() *principal {
+	return &principal{subject: client.ID, scopes: client.Scopes, namespaces: client.Namespaces, client: true}
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+func dummySecretHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy secret"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+func (c *Credentials) clientPrincipal(clientID string) (*principal, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	client, ok := c.clients[clientID]
+	if !ok {
+		return nil, false
+	}
+	return client.principal(), true
+}
+
+// regrant restricts the principal of a refreshed token to the scopes and
+// namespaces its client or shared secret is currently granted, so that
+// removing or downgrading credentials also applies to the tokens issued to
+// them. It returns nil if the credentials no longer exist or grant none of
+// the scopes and namespaces of the token.
+func (c *Credentials) regrant(p *principal) *principal {
+	c.RLock()
+	defer c.RUnlock()
+
+	var granted *principal
+	if p.client {
+		client, ok := c.clients[p.subject]
+		if !ok {
+			return nil
+		}
+		granted = client.principal()
+	} else {
+		granted = &principal{subject: p.subject}
+		for _, scopedSecret := range c.secrets {
+			if scopedSecret.Secret != "" && scopedSecret.Name == p.subject {
+				granted.scopes = append(granted.scopes, scopedSecret.Scopes...)
+			}
+		}
+	}
+
+	regranted := &principal{subject: p.subject, namespaces: p.namespaces, client: p.client}
+	for _, scope := range p.scopes {
+		if containsScope(granted.scopes, scope) {
+			regranted.scopes = append(regranted.scopes, scope)
+		}
+	}
+	if len(regranted.scopes) == 0 {
+		return nil
+	}
+
+	if granted.namespaces != nil {
+		if p.namespaces == nil {
+			regranted.namespaces = granted.namespaces
+		} else {
+			regranted.namespaces = []string{}
+			for _, namespace := range p.namespaces {
+				if containsScope(granted.namespaces, namespace) {
+					regranted.namespaces = append(regranted.namespaces, namespace)
+				}
+			}
+			if len(regranted.namespaces) == 0 {
+				return nil
+			}
+		}
+	}
+	return regranted
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadClientsFileRejectsUnknownScopes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	contents := `{"clients":[{"id":"billing","scopes":["items:read","items:wirte"]}]}`
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadClientsFile(path); err == nil {
+		t.Error("clients file with an unknown scope accepted")
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// parseTags rejects empty tags and drops duplicates.
+func parseTags(tags []string) ([]string, error) {
+	var parsedTags []string
+	seen := make(map[string]struct{})
+	for _, tag := range tags {
+		if tag == "" {
+			return nil, fmt.Errorf("invalid tag")
+		}
+		if _, ok := seen[tag]; !ok {
+			seen[tag] = struct{}{}
+			parsedTags = append(parsedTags, tag)
+		}
+	}
+	return parsedTags, nil
+}
+
+func deleteTaggedItemsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cache := getCache(ctx)
+	if cache == nil {
+		jsonError(w, "cache has not been initialized", http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tag := vars["tag"]
+	if tag == "" {
+		jsonError(w, "invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := cache.DeleteTagged(tag)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{"deleted": len(keys)}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
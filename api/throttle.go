@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	throttleBaseDelay    = time.Second
+	throttleGlobalWindow = time.Minute
+)
+
+type failedAttempts struct {
+	count        uint64
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// Throttle slows down guessing of secrets on /auth/token. Once an address has
+// failed more than maxFailures times in a row, each further failure doubles
+// the time it has to wait before trying again, up to maxBackoff. When more
+// than globalMaxFailures attempts fail within a minute across all addresses,
+// every address that has failed recently has to wait until the end of that
+// minute. Addresses without failures are never held back by the global
+// limit, so that failing clients cannot lock everyone else out.
+type Throttle struct {
+	maxFailures       uint64
+	maxBackoff        time.Duration
+	globalMaxFailures uint64
+	logger            *log.Logger
+
+	addresses      map[string]*failedAttempts
+	globalFailures uint64
+	globalWindow   time.Time
+
+	successfulAttempts uint64
+	failedAttempts     uint64
+	throttledAttempts  uint64
+	sync.Mutex
+}
+
+func NewThrottle(maxFailures uint64, maxBackoff time.Duration, globalMaxFailures uint64, logger *log.Logger) *Throttle {
+	return &Throttle{
+		maxFailures:       maxFailures,
+		maxBackoff:        maxBackoff,
+		globalMaxFailures: globalMaxFailures,
+		logger:            logger,
+		addresses:         make(map[string]*failedAttempts),
+	}
+}
+
+func (t *Throttle) SetLimits(maxFailures uint64, maxBackoff time.Duration, globalMaxFailures uint64) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.maxFailures = maxFailures
+	t.maxBackoff = maxBackoff
+	t.globalMaxFailures = globalMaxFailures
+}
+
+// wait returns how long the address has to wait before its next attempt.
+func (t *Throttle) wait(address string, now time.Time) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	attempts, ok := t.addresses[address]
+	if !ok {
+		return 0
+	}
+
+	var wait time.Duration
+	if now.Before(attempts.blockedUntil) {
+		wait = attempts.blockedUntil.Sub(now)
+	}
+	if t.globalMaxFailures != 0 && t.globalFailures >= t.globalMaxFailures {
+		if globalWait := t.globalWindow.Add(throttleGlobalWindow).Sub(now); globalWait > wait {
+			wait = globalWait
+		}
+	}
+
+	if wait > 0 {
+		t.throttledAttempts++
+	}
+	return wait
+}
+
+// fail records a failed attempt, and returns how long the address now has
+// to wait before its next attempt, along with the number of failures it has
+// left before it starts waiting.
+func (t *Throttle) fail(address string, now time.Time) (backoff time.Duration, remaining uint64) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.failedAttempts++
+	if now.Sub(t.globalWindow) >= throttleGlobalWindow {
+		t.globalWindow = now
+		t.globalFailures = 0
+	}
+	t.globalFailures++
+
+	attempts, ok := t.addresses[address]
+	if !ok {
+		attempts = &failedAttempts{}
+		t.addresses[address] = attempts
+	}
+	attempts.count++
+	attempts.lastFailure = now
+
+	if attempts.count > t.maxFailures {
+		backoff = t.maxBackoff
+		if exponent := attempts.count - t.maxFailures - 1; exponent < 32 {
+			backoff = time.Duration(math.Min(float64(throttleBaseDelay)*math.Pow(2, float64(exponent)), float64(t.maxBackoff)))
+		}
+		attempts.blockedUntil = now.Add(backoff)
+	} else {
+		remaining = t.maxFailures - attempts.count
+	}
+	if t.globalMaxFailures != 0 && t.globalFailures >= t.globalMaxFailures {
+		if globalWait := t.globalWindow.Add(throttleGlobalWindow).Sub(now); globalWait > backoff {
+			backoff = globalWait
+		}
+	}
+
+	t.logger.Println(
+		"authentication failed",
+		"address", address,
+		"failures", attempts.count,
+		"backoff", backoff,
+	)
+	return backoff, remaining
+}
+
+func (t *Throttle) succeed(address string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.successfulAttempts++
+	delete(t.addresses, address)
+}
+
+// DeleteExpired forgets the addresses that have not failed for longer than
+// the maximum backoff.
+func (t *Throttle) DeleteExpired(timeInterval time.Duration) {
+	ticker := time.NewTicker(timeInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		t.Lock()
+		for address, attempts := range t.addresses {
+			if time.Since(attempts.lastFailure) > t.maxBackoff && time.Now().After(attempts.blockedUntil) {
+				delete(t.addresses, address)
+			}
+		}
+		t.Unlock()
+	}
+}
+
+func (t *Throttle) metrics() map[string]interface{} {
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	var blockedAddresses uint64
+	for _, attempts := range t.addresses {
+		if now.Before(attempts.blockedUntil) {
+			blockedAddresses++
+		}
+	}
+
+	return map[string]interface{}{
+		"successfulAttempts": t.successfulAttempts,
+		"failedAttempts":     t.failedAttempts,
+		"throttledAttempts":  t.throttledAttempts,
+		"blockedAddresses":   blockedAddresses,
+	}
+}
+
+func remoteAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	setRetryAfter(w, wait)
+	jsonError(w, "too many failed attempts", http.StatusTooManyRequests)
+}
+
+// invalidSecret rejects a failed attempt, telling the client how many
+// attempts it has left or, once it has none, how long it has to wait.
+func invalidSecret(w http.ResponseWriter, backoff time.Duration, remaining uint64) {
+	if backoff > 0 {
+		setRetryAfter(w, backoff)
+	} else {
+		w.Header().Set("RateLimit-Remaining", strconv.FormatUint(remaining, 10))
+	}
+	jsonError(w, "invalid secret", http.StatusUnauthorized)
+}
+
+func getMetricsHandler(auth *Auth) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]interface{}{
+			"metrics": map[string]interface{}{"auth": auth.Throttle.metrics()},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
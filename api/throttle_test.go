@@ -0,0 +1,121 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestThrottle(maxFailures uint64, maxBackoff time.Duration, globalMaxFailures uint64) *Throttle {
+	return NewThrottle(maxFailures, maxBackoff, globalMaxFailures, log.New(io.Discard, "", 0))
+}
+
+func TestThrottleBackoffGrows(t *testing.T) {
+	throttle := newTestThrottle(2, 10*time.Second, 0)
+	now := time.Now()
+
+	for _, expected := range []uint64{1, 0} {
+		if backoff, remaining := throttle.fail("a", now); backoff != 0 || remaining != expected {
+			t.Fatalf("fail = %v, %v; expected no backoff and %v remaining", backoff, remaining, expected)
+		}
+		if wait := throttle.wait("a", now); wait != 0 {
+			t.Fatalf("wait = %v below the threshold", wait)
+		}
+	}
+
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		backoff, _ := throttle.fail("a", now)
+		if backoff != expected {
+			t.Fatalf("backoff = %v; expected %v", backoff, expected)
+		}
+		if wait := throttle.wait("a", now); wait != expected {
+			t.Fatalf("wait = %v; expected %v", wait, expected)
+		}
+		now = now.Add(backoff)
+	}
+}
+
+func TestThrottleLockoutExpires(t *testing.T) {
+	throttle := newTestThrottle(0, time.Minute, 0)
+	now := time.Now()
+
+	backoff, _ := throttle.fail("a", now)
+	if wait := throttle.wait("a", now.Add(backoff-time.Millisecond)); wait != time.Millisecond {
+		t.Errorf("wait = %v just before the end of the backoff; expected 1ms", wait)
+	}
+	if wait := throttle.wait("a", now.Add(backoff)); wait != 0 {
+		t.Errorf("wait = %v after the backoff; expected none", wait)
+	}
+	if wait := throttle.wait("b", now); wait != 0 {
+		t.Errorf("wait = %v for another address; expected none", wait)
+	}
+}
+
+func TestThrottleSuccessResetsFailures(t *testing.T) {
+	throttle := newTestThrottle(2, time.Minute, 0)
+	now := time.Now()
+
+	for i := 0; i < 4; i++ {
+		throttle.fail("a", now)
+	}
+	throttle.succeed("a")
+
+	if wait := throttle.wait("a", now); wait != 0 {
+		t.Errorf("wait = %v after a success; expected none", wait)
+	}
+	if backoff, remaining := throttle.fail("a", now); backoff != 0 || remaining != 1 {
+		t.Errorf("fail = %v, %v after a success; expected no backoff and 1 remaining", backoff, remaining)
+	}
+}
+
+func TestThrottleGlobalLimitSparesAddressesWithoutFailures(t *testing.T) {
+	throttle := newTestThrottle(5, time.Minute, 3)
+	now := time.Now()
+
+	throttle.fail("a", now)
+	throttle.fail("b", now)
+	if backoff, _ := throttle.fail("c", now); backoff != throttleGlobalWindow {
+		t.Errorf("backoff = %v when reaching the global limit; expected %v", backoff, throttleGlobalWindow)
+	}
+
+	if wait := throttle.wait("a", now.Add(time.Second)); wait != throttleGlobalWindow-time.Second {
+		t.Errorf("wait = %v for a failing address; expected %v", wait, throttleGlobalWindow-time.Second)
+	}
+	if wait := throttle.wait("d", now.Add(time.Second)); wait != 0 {
+		t.Errorf("wait = %v for an address without failures; expected none", wait)
+	}
+	if wait := throttle.wait("a", now.Add(throttleGlobalWindow)); wait != 0 {
+		t.Errorf("wait = %v after the global window; expected none", wait)
+	}
+}
+
+func TestCreateTokenReportsBackoff(t *testing.T) {
+	auth := &Auth{
+		Credentials: NewCredentials([]ScopedSecret{{Name: "admin", Secret: "secret", Scopes: []string{ScopeCacheAdmin}}}, nil),
+		Throttle:    newTestThrottle(1, time.Minute, 0),
+	}
+	handler := createTokenHandler(auth)
+
+	request := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/auth/token/", strings.NewReader(`{"secret":"wrong"}`)))
+		return recorder
+	}
+
+	response := request()
+	if response.Code != http.StatusUnauthorized || response.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("first failure: %v with RateLimit-Remaining %q; expected 401 with 0", response.Code, response.Header().Get("RateLimit-Remaining"))
+	}
+	response = request()
+	if response.Code != http.StatusUnauthorized || response.Header().Get("Retry-After") != "1" {
+		t.Errorf("second failure: %v with Retry-After %q; expected 401 with 1", response.Code, response.Header().Get("Retry-After"))
+	}
+	response = request()
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Errorf("early attempt: %v with Retry-After %q; expected 429 with a delay", response.Code, response.Header().Get("Retry-After"))
+	}
+}
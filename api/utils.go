@@ -3,7 +3,10 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
+	"path/filepath"
 
 	"github.com/infamous55/go-zestful/cache"
 )
@@ -11,7 +14,9 @@ import (
 type contextKey string
 
 const (
-	cacheKey contextKey = "cache"
+	cacheKey     contextKey = "cache"
+	principalKey contextKey = "principal"
+	logEntryKey  contextKey = "logEntry"
 )
 
 func getCache(ctx context.Context) cache.Cache {
@@ -27,3 +32,33 @@ func jsonError(w http.ResponseWriter, message string, statusCode int) {
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(errorResponse)
 }
+
+func setErrorStatus(err error) int {
+	if errors.Is(err, cache.ErrItemTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// writeFileAtomically replaces the file at path, so that readers never see
+// it partially written.
+func writeFileAtomically(path string, contents []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
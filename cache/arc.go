@@ -0,0 +1,403 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// ARCCache implements Adaptive Replacement Cache. Resident items are split
+// between a list of items seen once recently (T1) and a list of items seen
+// at least twice (T2), both ordered from most to least recently used. The
+// keys evicted from each list are remembered in a ghost list (B1 and B2). A
+// miss on a ghost key means that its list was too short, so the target size
+// of T1 grows on B1 hits and shrinks on B2 hits.
+type ARCCache struct {
+	cacheInfo
+	recent         *list.List
+	frequent       *list.List
+	recentGhosts   *list.List
+	frequentGhosts *list.List
+	items          map[string]*list.Element
+	ghosts         map[string]*list.Element
+	target         uint64
+}
+
+type arcItem struct {
+	*cacheItem
+	frequent bool
+}
+
+type arcGhost struct {
+	key      string
+	frequent bool
+}
+
+func (c *ARCCache) reset() {
+	c.recent = &list.List{}
+	c.frequent = &list.List{}
+	c.recentGhosts = &list.List{}
+	c.frequentGhosts = &list.List{}
+	c.items = make(map[string]*list.Element)
+	c.ghosts = make(map[string]*list.Element)
+	c.target = 0
+	c.clear()
+}
+
+// ghostCapacity is the number of items the lists are balanced against. A
+// cache limited only by memory uses the number of items it currently holds.
+func (c *ARCCache) ghostCapacity() uint64 {
+	if c.capacity != 0 {
+		return c.capacity
+	}
+	return c.size
+}
+
+func (c *ARCCache) Set(key string, value interface{}, timeToLive ...time.Duration) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.set(key, value, nil, timeToLive...)
+}
+
+func (c *ARCCache) SetItem(item Item) (err error) {
+	return c.setItem(c, item)
+}
+
+func (c *ARCCache) SetMany(items []Item) (errs []error) {
+	return c.setMany(c, items)
+}
+
+func (c *ARCCache) set(key string, value interface{}, tags []string, timeToLive ...time.Duration) (err error) {
+	itemSize := estimateSize(key, value) + estimateTagsSize(tags)
+	if !c.fits(itemSize) {
+		return ErrItemTooLarge
+	}
+
+	var item *arcItem
+	if listElement, ok := c.items[key]; ok {
+		listElement = c.promote(listElement)
+		item = listElement.Value.(*arcItem)
+		item.value = value
+		c.memoryUsage = c.memoryUsage - item.size + itemSize
+		item.size = itemSize
+
+		for c.exceedsMemory(0) {
+			c.replace(false, listElement)
+		}
+	} else {
+		item = &arcItem{cacheItem: &cacheItem{key: key, value: value, size: itemSize}}
+		if ghostElement, ok := c.ghosts[key]; ok {
+			ghost := ghostElement.Value.(*arcGhost)
+			c.adapt(ghost.frequent)
+			c.removeGhost(ghostElement)
+
+			for c.size > 0 && c.isFull(itemSize) {
+				c.replace(ghost.frequent, nil)
+			}
+			item.frequent = true
+			c.items[key] = c.frequent.PushFront(item)
+		} else {
+			for c.size > 0 && c.isFull(itemSize) {
+				c.replace(false, nil)
+			}
+			c.items[key] = c.recent.PushFront(item)
+		}
+		c.addItem(item.cacheItem)
+		c.trimGhosts()
+	}
+
+	c.updateItem(item.cacheItem, tags, timeToLive...)
+
+	return nil
+}
+
+// adapt moves the target size of T1 towards the list whose ghost was hit,
+// by a step proportional to the relative sizes of the ghost lists.
+func (c *ARCCache) adapt(frequent bool) {
+	recentGhosts, frequentGhosts := uint64(c.recentGhosts.Len()), uint64(c.frequentGhosts.Len())
+	if !frequent {
+		step := uint64(1)
+		if recentGhosts != 0 && frequentGhosts > recentGhosts {
+			step = frequentGhosts / recentGhosts
+		}
+		c.target += step
+		if limit := c.ghostCapacity(); c.target > limit {
+			c.target = limit
+		}
+	} else {
+		step := uint64(1)
+		if frequentGhosts != 0 && recentGhosts > frequentGhosts {
+			step = recentGhosts / frequentGhosts
+		}
+		if step > c.target {
+			c.target = 0
+		} else {
+			c.target -= step
+		}
+	}
+}
+
+// replace evicts the least recently used item of T1 if T1 exceeds its
+// target size, or of T2 otherwise, and remembers its key in the matching
+// ghost list. The protected element, which is being updated, is spared.
+func (c *ARCCache) replace(frequentGhostHit bool, protected *list.Element) {
+	recentSize := uint64(c.recent.Len())
+	fromRecent := recentSize > 0 && (recentSize > c.target || (frequentGhostHit && recentSize == c.target) ||
+		c.frequent.Len() == 0 || c.frequent.Back() == protected)
+
+	listElement := c.frequent.Back()
+	if fromRecent {
+		listElement = c.recent.Back()
+	}
+	if listElement == nil || listElement == protected {
+		return
+	}
+
+	item := listElement.Value.(*arcItem)
+	c.removeCacheItem(listElement, item.key)
+
+	ghost := &arcGhost{key: item.key, frequent: item.frequent}
+	if item.frequent {
+		c.ghosts[item.key] = c.frequentGhosts.PushFront(ghost)
+	} else {
+		c.ghosts[item.key] = c.recentGhosts.PushFront(ghost)
+	}
+}
+
+// trimGhosts keeps T1 and B1 within the capacity, and all four lists within
+// twice the capacity.
+func (c *ARCCache) trimGhosts() {
+	limit := c.ghostCapacity()
+	for c.recentGhosts.Len() > 0 && uint64(c.recent.Len()+c.recentGhosts.Len()) > limit {
+		c.removeGhost(c.recentGhosts.Back())
+	}
+	for c.frequentGhosts.Len() > 0 && uint64(c.recent.Len()+c.frequent.Len()+c.recentGhosts.Len()+c.frequentGhosts.Len()) > 2*limit {
+		c.removeGhost(c.frequentGhosts.Back())
+	}
+	for c.recentGhosts.Len() > 0 && uint64(c.recent.Len()+c.frequent.Len()+c.recentGhosts.Len()+c.frequentGhosts.Len()) > 2*limit {
+		c.removeGhost(c.recentGhosts.Back())
+	}
+}
+
+func (c *ARCCache) removeGhost(ghostElement *list.Element) {
+	ghost := ghostElement.Value.(*arcGhost)
+	if ghost.frequent {
+		c.frequentGhosts.Remove(ghostElement)
+	} else {
+		c.recentGhosts.Remove(ghostElement)
+	}
+	delete(c.ghosts, ghost.key)
+}
+
+// promote moves an item to the front of T2, and returns its new element.
+func (c *ARCCache) promote(listElement *list.Element) *list.Element {
+	item := listElement.Value.(*arcItem)
+	if item.frequent {
+		c.frequent.MoveToFront(listElement)
+		return listElement
+	}
+
+	c.recent.Remove(listElement)
+	item.frequent = true
+	listElement = c.frequent.PushFront(item)
+	c.items[item.key] = listElement
+	return listElement
+}
+
+func (c *ARCCache) Get(key string) (value interface{}, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	item, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.value, nil
+}
+
+func (c *ARCCache) GetItem(key string) (item Item, err error) {
+	return c.getItem(c, key)
+}
+
+func (c *ARCCache) GetMany(keys []string) (items []Item, errs []error) {
+	return c.getMany(c, keys)
+}
+
+func (c *ARCCache) get(key string) (item *cacheItem, err error) {
+	if listElement, ok := c.items[key]; ok {
+		item := listElement.Value.(*arcItem)
+
+		if !item.expirationTime.IsZero() && time.Now().After(item.expirationTime) {
+			c.removeCacheItem(listElement, key)
+
+			return nil, fmt.Errorf("item does not exist")
+		}
+
+		c.promote(listElement)
+		item.lastAccess = time.Now()
+
+		return item.cacheItem, nil
+	} else {
+		return nil, fmt.Errorf("item does not exist")
+	}
+}
+
+func (c *ARCCache) Delete(key string) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.delete(c, key)
+}
+
+func (c *ARCCache) DeleteMany(keys []string) (errs []error) {
+	return c.deleteMany(c, keys)
+}
+
+func (c *ARCCache) remove(key string) {
+	if listElement, ok := c.items[key]; ok {
+		c.removeCacheItem(listElement, key)
+	}
+}
+
+func (c *ARCCache) DeleteMatching(pattern *regexp.Regexp) (keys []string, err error) {
+	return c.deleteMatching(c, pattern), nil
+}
+
+func (c *ARCCache) DeleteTagged(tag string) (keys []string, err error) {
+	return c.deleteTagged(c, tag), nil
+}
+
+func (c *ARCCache) Purge() (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.reset()
+	return nil
+}
+
+func (c *ARCCache) removeCacheItem(listElement *list.Element, key string) {
+	item := listElement.Value.(*arcItem)
+	if item.frequent {
+		c.frequent.Remove(listElement)
+	} else {
+		c.recent.Remove(listElement)
+	}
+	c.removeItem(item.cacheItem)
+	delete(c.items, key)
+}
+
+func (c *ARCCache) DeleteExpired(timeInterval time.Duration, stop <-chan struct{}) {
+	c.deleteExpired(c, timeInterval, stop)
+}
+
+func (c *ARCCache) Scan(prefix string, cursor string, limit int) (keys []KeyInfo, nextCursor string, err error) {
+	keys, nextCursor = c.scan(c, prefix, cursor, limit)
+	return keys, nextCursor, nil
+}
+
+func (c *ARCCache) forEachItem(visit func(item *cacheItem)) {
+	for _, listElement := range c.items {
+		visit(listElement.Value.(*arcItem).cacheItem)
+	}
+}
+
+func (c *ARCCache) keyInfo(key string, now time.Time) (info KeyInfo, ok bool) {
+	listElement, ok := c.items[key]
+	if !ok || listElement.Value.(*arcItem).isExpired(now) {
+		return KeyInfo{}, false
+	}
+	return newKeyInfo(listElement.Value.(*arcItem).cacheItem, now), true
+}
+
+func (c *ARCCache) Info() (info map[string]interface{}, err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	info = c.info()
+	info["recentSize"] = c.recent.Len()
+	info["frequentSize"] = c.frequent.Len()
+	info["recentGhosts"] = c.recentGhosts.Len()
+	info["frequentGhosts"] = c.frequentGhosts.Len()
+	info["recentTarget"] = c.target
+	return info, nil
+}
+
+func (c *ARCCache) Resize(capacity uint64, maxMemory uint64) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.capacity = capacity
+	c.maxMemory = maxMemory
+	for c.size > 0 && c.isOverLimit() {
+		c.replace(false, nil)
+	}
+	if limit := c.ghostCapacity(); c.target > limit {
+		c.target = limit
+	}
+	c.trimGhosts()
+	return nil
+}
+
+// Save stores T1 and then T2, each from the least to the most recently used
+// item. Items of T2 are marked with a frequency of 1. Ghost keys are not
+// saved.
+func (c *ARCCache) Save(w io.Writer) (err error) {
+	c.RLock()
+	items := make([]snapshotItem, 0, c.size)
+	for _, positionList := range []*list.List{c.recent, c.frequent} {
+		for listElement := positionList.Back(); listElement != nil; listElement = listElement.Prev() {
+			item := listElement.Value.(*arcItem)
+			snapshotItem := snapshotItem{
+				Key:            item.key,
+				Value:          item.value,
+				ExpirationTime: item.expirationTime,
+				Tags:           item.tags,
+			}
+			if item.frequent {
+				snapshotItem.Frequency = 1
+			}
+			items = append(items, snapshotItem)
+		}
+	}
+	c.RUnlock()
+
+	return writeSnapshot(w, ARC, items)
+}
+
+func (c *ARCCache) Load(r io.Reader) (err error) {
+	s, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.reset()
+	c.load(c, s.Items)
+
+	// Evicting while loading only fills the ghost lists with keys that were
+	// not restored, which says nothing about the workload.
+	c.recentGhosts = &list.List{}
+	c.frequentGhosts = &list.List{}
+	c.ghosts = make(map[string]*list.Element)
+	return nil
+}
+
+func (c *ARCCache) restore(cacheItem *cacheItem, frequency uint64) {
+	for c.size > 0 && c.isFull(cacheItem.size) {
+		c.replace(false, nil)
+	}
+
+	item := &arcItem{cacheItem: cacheItem, frequent: frequency != 0}
+	if item.frequent {
+		c.items[item.key] = c.frequent.PushFront(item)
+	} else {
+		c.items[item.key] = c.recent.PushFront(item)
+	}
+	c.tagItem(item.cacheItem)
+	c.addItem(item.cacheItem)
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// arcState lists the keys of the four ARC lists from most to least recently
+// used, along with the target size of T1.
+type arcState struct {
+	recent, frequent, recentGhosts, frequentGhosts []string
+	target                                         uint64
+}
+
+func arcKeys(l *list.List) []string {
+	keys := make([]string, 0, l.Len())
+	for listElement := l.Front(); listElement != nil; listElement = listElement.Next() {
+		switch value := listElement.Value.(type) {
+		case *arcItem:
+			keys = append(keys, value.key)
+		case *arcGhost:
+			keys = append(keys, value.key)
+		}
+	}
+	return keys
+}
+
+func (c *ARCCache) state() arcState {
+	return arcState{
+		recent:         arcKeys(c.recent),
+		frequent:       arcKeys(c.frequent),
+		recentGhosts:   arcKeys(c.recentGhosts),
+		frequentGhosts: arcKeys(c.frequentGhosts),
+		target:         c.target,
+	}
+}
+
+func TestARC(t *testing.T) {
+	tests := []struct {
+		name       string
+		capacity   uint64
+		operations []string
+		expected   arcState
+	}{
+		{
+			"new items enter T1",
+			2,
+			[]string{"set a", "set b"},
+			arcState{recent: []string{"b", "a"}, frequent: []string{}, recentGhosts: []string{}, frequentGhosts: []string{}},
+		},
+		{
+			"a read promotes an item from T1 to T2",
+			2,
+			[]string{"set a", "set b", "get a"},
+			arcState{recent: []string{"b"}, frequent: []string{"a"}, recentGhosts: []string{}, frequentGhosts: []string{}},
+		},
+		{
+			"an update promotes an item from T1 to T2",
+			2,
+			[]string{"set a", "set a"},
+			arcState{recent: []string{}, frequent: []string{"a"}, recentGhosts: []string{}, frequentGhosts: []string{}},
+		},
+		{
+			"a full T1 drops its least recently used item without a ghost",
+			2,
+			[]string{"set a", "set b", "set c"},
+			arcState{recent: []string{"c", "b"}, frequent: []string{}, recentGhosts: []string{}, frequentGhosts: []string{}},
+		},
+		{
+			"items evicted from T1 are remembered in B1",
+			3,
+			[]string{"set a", "get a", "set b", "set c", "set d"},
+			arcState{recent: []string{"d", "c"}, frequent: []string{"a"}, recentGhosts: []string{"b"}, frequentGhosts: []string{}},
+		},
+		{
+			"items evicted from T2 are remembered in B2",
+			2,
+			[]string{"set a", "get a", "set b", "get b", "set c"},
+			arcState{recent: []string{"c"}, frequent: []string{"b"}, recentGhosts: []string{}, frequentGhosts: []string{"a"}},
+		},
+		{
+			"a B1 hit grows the target and enters T2",
+			3,
+			[]string{"set a", "get a", "set b", "set c", "set d", "set b"},
+			arcState{recent: []string{"d"}, frequent: []string{"b", "a"}, recentGhosts: []string{"c"}, frequentGhosts: []string{}, target: 1},
+		},
+		{
+			"a B2 hit shrinks the target and enters T2",
+			3,
+			[]string{"set a", "get a", "set b", "set c", "set d", "set b", "get d", "set e", "set a"},
+			arcState{recent: []string{}, frequent: []string{"a", "d", "b"}, recentGhosts: []string{"e", "c"}, frequentGhosts: []string{}, target: 0},
+		},
+		{
+			"the target grows faster when B2 is larger than B1",
+			4,
+			[]string{
+				"set a", "get a", "set b", "get b", "set c", "get c", "set d", "get d",
+				"set e", "get e", "set f", "get f", "set g", "get g", "set h", "set i", "set h",
+			},
+			arcState{recent: []string{"i"}, frequent: []string{"h", "g", "f"}, recentGhosts: []string{}, frequentGhosts: []string{"e", "d", "c", "b"}, target: 3},
+		},
+	}
+	for _, test := range tests {
+		c, _ := New(test.capacity, 0, ARC, 0)
+		arc := c.(*ARCCache)
+		runOperations(arc, test.operations)
+		if state := arc.state(); !reflect.DeepEqual(state, test.expected) {
+			t.Errorf("%v: %+v; expected %+v", test.name, state, test.expected)
+		}
+	}
+}
+
+func TestARCGhostsStayBounded(t *testing.T) {
+	const capacity = 8
+	c, _ := New(capacity, 0, ARC, 0)
+	arc := c.(*ARCCache)
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint(random.Intn(5 * capacity))
+		if random.Intn(2) == 0 {
+			arc.Set(key, key)
+		} else {
+			arc.Get(key)
+		}
+
+		recent, frequent := arc.recent.Len(), arc.frequent.Len()
+		recentGhosts, frequentGhosts := arc.recentGhosts.Len(), arc.frequentGhosts.Len()
+		if recent+frequent > capacity || recent+recentGhosts > capacity || recent+frequent+recentGhosts+frequentGhosts > 2*capacity {
+			t.Fatalf("after %v operations, T1 = %v, T2 = %v, B1 = %v, B2 = %v; expected T1+T2 and T1+B1 within %v and all lists within %v",
+				i+1, recent, frequent, recentGhosts, frequentGhosts, capacity, 2*capacity)
+		}
+		if len(arc.ghosts) != recentGhosts+frequentGhosts || arc.target > capacity {
+			t.Fatalf("after %v operations, %v ghost keys for %v ghosts, target %v", i+1, len(arc.ghosts), recentGhosts+frequentGhosts, arc.target)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// fifoItem is an item of the SIEVE and S3-FIFO caches, which record hits
+// under the read lock. A hit only raises the frequency of the item, up to a
+// small limit, and its last access time, both atomically. Every other field
+// is only written under the write lock.
+type fifoItem struct {
+	*cacheItem
+	frequency atomic.Int32
+	accessed  atomic.Int64
+	small     bool
+}
+
+func newFIFOItem(item *cacheItem) *fifoItem {
+	fifoItem := &fifoItem{cacheItem: item}
+	fifoItem.accessed.Store(time.Now().UnixNano())
+	return fifoItem
+}
+
+// touch records a hit. The frequency is only written when it changes, so
+// that hits on popular items do not keep invalidating their cache line.
+func (item *fifoItem) touch(maxFrequency int32) {
+	item.accessed.Store(time.Now().UnixNano())
+	for {
+		frequency := item.frequency.Load()
+		if frequency >= maxFrequency || item.frequency.CompareAndSwap(frequency, frequency+1) {
+			return
+		}
+	}
+}
+
+func (item *fifoItem) keyInfo(now time.Time) KeyInfo {
+	keyInfo := newKeyInfo(item.cacheItem, now)
+	keyInfo.LastAccess = time.Unix(0, item.accessed.Load())
+	return keyInfo
+}
@@ -0,0 +1,536 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+type FsyncPolicy string
+
+const (
+	FsyncAlways      FsyncPolicy = "always"
+	FsyncEverySecond FsyncPolicy = "everysec"
+	FsyncNever       FsyncPolicy = "never"
+)
+
+func (fp *FsyncPolicy) Set(value string) error {
+	switch value {
+	case "always", "everysec", "never":
+		*fp = FsyncPolicy(value)
+		return nil
+	default:
+		return fmt.Errorf("parse error")
+	}
+}
+
+func (fp *FsyncPolicy) String() string {
+	return string(*fp)
+}
+
+const (
+	setOperation    = "set"
+	deleteOperation = "delete"
+	purgeOperation  = "purge"
+)
+
+type journalEntry struct {
+	Operation      string      `json:"op"`
+	Key            string      `json:"key,omitempty"`
+	Value          interface{} `json:"value,omitempty"`
+	ExpirationTime *time.Time  `json:"expirationTime,omitempty"`
+	Frequency      uint64      `json:"frequency,omitempty"`
+	Tags           []string    `json:"tags,omitempty"`
+}
+
+// JournaledCache records every Set, Delete and Purge in an append-only log
+// before acknowledging it, so that the contents of the wrapped cache can be
+// rebuilt after a crash. The log is compacted in the background once it
+// grows past the rewrite threshold.
+type JournaledCache struct {
+	Cache
+	path        string
+	fsyncPolicy FsyncPolicy
+	defaultTtl  time.Duration
+
+	file            *os.File
+	writer          *bufio.Writer
+	logSize         uint64
+	rewriteSize     uint64
+	nextRewriteSize uint64
+	rewriting       bool
+	rewriteBuffer   []journalEntry
+	closed          chan struct{}
+	closeOnce       sync.Once
+	sync.Mutex
+}
+
+// OpenJournal replays the log stored at path into c and returns a cache that
+// appends every subsequent write to it. If the log does not exist yet, it is
+// created from the current contents of c.
+func OpenJournal(c Cache, path string, fsyncPolicy FsyncPolicy, rewriteSize uint64, defaultTtl time.Duration) (*JournaledCache, error) {
+	jc := &JournaledCache{
+		Cache:           c,
+		path:            path,
+		fsyncPolicy:     fsyncPolicy,
+		defaultTtl:      defaultTtl,
+		rewriteSize:     rewriteSize,
+		nextRewriteSize: rewriteSize,
+		closed:          make(chan struct{}),
+	}
+
+	err := jc.replay()
+	if errors.Is(err, os.ErrNotExist) {
+		err = jc.rewrite()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if jc.file == nil {
+		jc.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		jc.writer = bufio.NewWriter(jc.file)
+	}
+
+	if fsyncPolicy == FsyncEverySecond {
+		go jc.syncPeriodically(time.Second)
+	}
+
+	return jc, nil
+}
+
+func (jc *JournaledCache) replay() error {
+	file, err := os.OpenFile(jc.path, os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var (
+		items   []snapshotItem
+		indexes = make(map[string]int)
+		offset  int64
+		reader  = bufio.NewReader(file)
+	)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) != 0 {
+			var entry journalEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				if readErr == io.EOF {
+					// The last entry was only partially written before a
+					// crash, so it is dropped from the log.
+					if err := file.Truncate(offset); err != nil {
+						return err
+					}
+					break
+				}
+				return fmt.Errorf("invalid journal entry at offset %v: %v", offset, err)
+			}
+
+			switch entry.Operation {
+			case setOperation:
+				item := snapshotItem{Key: entry.Key, Value: entry.Value, Frequency: entry.Frequency, Tags: entry.Tags}
+				if entry.ExpirationTime != nil {
+					item.ExpirationTime = *entry.ExpirationTime
+				}
+				if index, ok := indexes[entry.Key]; ok {
+					if item.Frequency == 0 {
+						item.Frequency = items[index].Frequency
+					}
+					items[index].Key = ""
+				}
+				indexes[entry.Key] = len(items)
+				items = append(items, item)
+			case deleteOperation:
+				if index, ok := indexes[entry.Key]; ok {
+					items[index].Key = ""
+					delete(indexes, entry.Key)
+				}
+			case purgeOperation:
+				items = nil
+				indexes = make(map[string]int)
+			default:
+				return fmt.Errorf("invalid journal operation %q at offset %v", entry.Operation, offset)
+			}
+		}
+		offset += int64(len(line))
+
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+	jc.logSize = uint64(offset)
+	if jc.rewriteSize != 0 && jc.logSize*2 > jc.rewriteSize {
+		jc.nextRewriteSize = jc.logSize * 2
+	}
+
+	liveItems := make([]snapshotItem, 0, len(indexes))
+	for _, item := range items {
+		if item.Key != "" {
+			liveItems = append(liveItems, item)
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := writeSnapshot(&buffer, "", liveItems); err != nil {
+		return err
+	}
+	return jc.Cache.Load(&buffer)
+}
+
+func (jc *JournaledCache) expirationTime(timeToLive []time.Duration) *time.Time {
+	var expirationTime time.Time
+	if len(timeToLive) == 1 && timeToLive[0] != 0 {
+		expirationTime = time.Now().Add(timeToLive[0])
+	} else if jc.defaultTtl != 0 {
+		expirationTime = time.Now().Add(jc.defaultTtl)
+	} else {
+		return nil
+	}
+	return &expirationTime
+}
+
+func (jc *JournaledCache) append(entry journalEntry) error {
+	if jc.rewriting {
+		jc.rewriteBuffer = append(jc.rewriteBuffer, entry)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	// The entry is handed to the operating system before the write is
+	// acknowledged, so that it survives a crash of the process. Only forcing
+	// it to disk depends on the fsync policy.
+	if _, err := jc.writer.Write(line); err != nil {
+		return err
+	}
+	if err := jc.writer.Flush(); err != nil {
+		return err
+	}
+	jc.logSize += uint64(len(line))
+
+	if jc.fsyncPolicy == FsyncAlways {
+		if err := jc.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if !jc.rewriting && jc.nextRewriteSize != 0 && jc.logSize >= jc.nextRewriteSize {
+		jc.rewriting = true
+		jc.rewriteBuffer = nil
+		go jc.rewrite()
+	}
+	return nil
+}
+
+func (jc *JournaledCache) sync() error {
+	if err := jc.writer.Flush(); err != nil {
+		return err
+	}
+	return jc.file.Sync()
+}
+
+func (jc *JournaledCache) syncPeriodically(timeInterval time.Duration) {
+	ticker := time.NewTicker(timeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			jc.Lock()
+			jc.sync()
+			jc.Unlock()
+		case <-jc.closed:
+			return
+		}
+	}
+}
+
+func (jc *JournaledCache) Set(key string, value interface{}, timeToLive ...time.Duration) (err error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	if err := jc.Cache.Set(key, value, timeToLive...); err != nil {
+		return err
+	}
+	return jc.append(journalEntry{
+		Operation:      setOperation,
+		Key:            key,
+		Value:          value,
+		ExpirationTime: jc.expirationTime(timeToLive),
+	})
+}
+
+func (jc *JournaledCache) Delete(key string) (err error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	if err := jc.Cache.Delete(key); err != nil {
+		return err
+	}
+	return jc.append(journalEntry{Operation: deleteOperation, Key: key})
+}
+
+func (jc *JournaledCache) SetItem(item Item) (err error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	if err := jc.Cache.SetItem(item); err != nil {
+		return err
+	}
+	return jc.append(journalEntry{
+		Operation:      setOperation,
+		Key:            item.Key,
+		Value:          item.Value,
+		ExpirationTime: jc.expirationTime([]time.Duration{item.TimeToLive}),
+		Tags:           item.Tags,
+	})
+}
+
+func (jc *JournaledCache) SetMany(items []Item) (errs []error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	errs = jc.Cache.SetMany(items)
+	for i, item := range items {
+		if errs[i] != nil {
+			continue
+		}
+		errs[i] = jc.append(journalEntry{
+			Operation:      setOperation,
+			Key:            item.Key,
+			Value:          item.Value,
+			ExpirationTime: jc.expirationTime([]time.Duration{item.TimeToLive}),
+			Tags:           item.Tags,
+		})
+	}
+	return errs
+}
+
+func (jc *JournaledCache) DeleteMany(keys []string) (errs []error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	errs = jc.Cache.DeleteMany(keys)
+	for i, key := range keys {
+		if errs[i] == nil {
+			errs[i] = jc.append(journalEntry{Operation: deleteOperation, Key: key})
+		}
+	}
+	return errs
+}
+
+func (jc *JournaledCache) DeleteMatching(pattern *regexp.Regexp) (keys []string, err error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	keys, err = jc.Cache.DeleteMatching(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := jc.append(journalEntry{Operation: deleteOperation, Key: key}); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
+func (jc *JournaledCache) DeleteTagged(tag string) (keys []string, err error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	keys, err = jc.Cache.DeleteTagged(tag)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := jc.append(journalEntry{Operation: deleteOperation, Key: key}); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
+func (jc *JournaledCache) Purge() (err error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	if err := jc.Cache.Purge(); err != nil {
+		return err
+	}
+	return jc.append(journalEntry{Operation: purgeOperation})
+}
+
+func (jc *JournaledCache) SetDefaultTtl(defaultTtl time.Duration) (err error) {
+	jc.Lock()
+	defer jc.Unlock()
+
+	if err := jc.Cache.SetDefaultTtl(defaultTtl); err != nil {
+		return err
+	}
+	jc.defaultTtl = defaultTtl
+	return nil
+}
+
+func (jc *JournaledCache) Load(r io.Reader) (err error) {
+	if err := jc.Cache.Load(r); err != nil {
+		return err
+	}
+	return jc.Rewrite()
+}
+
+// Rewrite compacts the log into one entry per live item.
+func (jc *JournaledCache) Rewrite() error {
+	jc.Lock()
+	if jc.rewriting {
+		jc.Unlock()
+		return fmt.Errorf("journal rewrite already in progress")
+	}
+	jc.rewriting = true
+	jc.rewriteBuffer = nil
+	jc.Unlock()
+
+	return jc.rewrite()
+}
+
+func (jc *JournaledCache) rewrite() error {
+	var buffer bytes.Buffer
+	err := jc.Cache.Save(&buffer)
+
+	var s *snapshot
+	if err == nil {
+		s, err = readSnapshot(&buffer)
+	}
+
+	var file *os.File
+	if err == nil {
+		file, err = os.CreateTemp(filepath.Dir(jc.path), filepath.Base(jc.path)+".*.tmp")
+	}
+	if err != nil {
+		jc.Lock()
+		jc.rewriting = false
+		jc.Unlock()
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for i := range s.Items {
+		entry := journalEntry{
+			Operation: setOperation,
+			Key:       s.Items[i].Key,
+			Value:     s.Items[i].Value,
+			Frequency: s.Items[i].Frequency,
+			Tags:      s.Items[i].Tags,
+		}
+		if !s.Items[i].ExpirationTime.IsZero() {
+			entry.ExpirationTime = &s.Items[i].ExpirationTime
+		}
+		if err = encoder.Encode(entry); err != nil {
+			break
+		}
+	}
+
+	jc.Lock()
+	defer jc.Unlock()
+	defer func() {
+		jc.rewriting = false
+		jc.rewriteBuffer = nil
+	}()
+
+	// A rewrite finishing after Close is discarded, since the new log would
+	// never be closed.
+	if jc.isClosed() {
+		file.Close()
+		return os.ErrClosed
+	}
+
+	// Writes that reached the cache while the log was being rewritten may or
+	// may not be part of the snapshot, so they are appended once more.
+	// Replaying them twice yields the same contents.
+	for _, entry := range jc.rewriteBuffer {
+		if err != nil {
+			break
+		}
+		err = encoder.Encode(entry)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), jc.path)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if jc.file != nil {
+		jc.writer.Flush()
+		jc.file.Close()
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	jc.file = file
+	jc.writer = bufio.NewWriter(file)
+	jc.logSize = uint64(info.Size())
+	// Like the initial threshold, the next rewrite is postponed until the log
+	// has at least doubled, so that large caches are not rewritten over and
+	// over again.
+	jc.nextRewriteSize = jc.rewriteSize
+	if jc.rewriteSize != 0 && jc.logSize*2 > jc.rewriteSize {
+		jc.nextRewriteSize = jc.logSize * 2
+	}
+	return nil
+}
+
+func (jc *JournaledCache) isClosed() bool {
+	select {
+	case <-jc.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close flushes pending entries to disk and closes the log. Closing it again
+// returns os.ErrClosed.
+func (jc *JournaledCache) Close() (err error) {
+	err = os.ErrClosed
+	jc.closeOnce.Do(func() {
+		jc.Lock()
+		defer jc.Unlock()
+
+		close(jc.closed)
+		err = jc.sync()
+		if closeErr := jc.file.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestJournal(t *testing.T, path string, rewriteSize uint64) *JournaledCache {
+	t.Helper()
+
+	c, err := New(100, 0, LRU, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := OpenJournal(c, path, FsyncNever, rewriteSize, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return journal
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	journal := openTestJournal(t, path, 0)
+	journal.Set("a", "1")
+	journal.Set("b", "2")
+	journal.Set("a", "3")
+	journal.Delete("b")
+	journal.SetItem(Item{Key: "c", Value: "4", Tags: []string{"t"}})
+	journal.Set("d", "5", time.Nanosecond)
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	journal = openTestJournal(t, path, 0)
+	defer journal.Close()
+
+	if value, err := journal.Get("a"); err != nil || value != "3" {
+		t.Errorf("a = %v, %v; expected 3", value, err)
+	}
+	if _, err := journal.Get("b"); err == nil {
+		t.Errorf("b was deleted but replayed")
+	}
+	if _, err := journal.Get("d"); err == nil {
+		t.Errorf("d has expired but was replayed")
+	}
+	if keys, _ := journal.DeleteTagged("t"); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("tagged keys = %v; expected [c]", keys)
+	}
+}
+
+func TestJournalReplayAfterPurge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	journal := openTestJournal(t, path, 0)
+	journal.Set("a", "1")
+	journal.Purge()
+	journal.Set("b", "2")
+	journal.Close()
+
+	journal = openTestJournal(t, path, 0)
+	defer journal.Close()
+
+	if _, err := journal.Get("a"); err == nil {
+		t.Errorf("a was purged but replayed")
+	}
+	if value, err := journal.Get("b"); err != nil || value != "2" {
+		t.Errorf("b = %v, %v; expected 2", value, err)
+	}
+}
+
+func TestJournalFlushesEveryEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	journal := openTestJournal(t, path, 0)
+	defer journal.Close()
+	journal.Set("a", "1")
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), `"key":"a"`) {
+		t.Errorf("journal = %q; expected the entry of a before closing", contents)
+	}
+}
+
+func TestJournalTruncatesPartialLastEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	journal := openTestJournal(t, path, 0)
+	journal.Set("a", "1")
+	journal.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"set","key":"b","val`)
+	file.Close()
+
+	journal = openTestJournal(t, path, 0)
+	if truncated, err := os.Stat(path); err != nil || truncated.Size() != info.Size() {
+		t.Errorf("journal was not truncated to %v bytes", info.Size())
+	}
+	if _, err := journal.Get("b"); err == nil {
+		t.Errorf("b was only partially written but replayed")
+	}
+	journal.Set("c", "2")
+	journal.Close()
+
+	journal = openTestJournal(t, path, 0)
+	defer journal.Close()
+	for _, key := range []string{"a", "c"} {
+		if _, err := journal.Get(key); err != nil {
+			t.Errorf("%v was not replayed after the truncation: %v", key, err)
+		}
+	}
+}
+
+func TestJournalRejectsCorruptEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	os.WriteFile(path, []byte("not json\n{\"op\":\"purge\"}\n"), 0o600)
+
+	c, _ := New(100, 0, LRU, 0)
+	if _, err := OpenJournal(c, path, FsyncNever, 0, 0); err == nil {
+		t.Errorf("a corrupt entry in the middle of the journal was accepted")
+	}
+}
+
+func TestJournalRewritesInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	journal := openTestJournal(t, path, 4096)
+
+	// Overwriting the same keys grows the log until a rewrite starts, and
+	// the writes made while it runs have to survive it.
+	written := 0
+	for rewriting := false; !rewriting; written++ {
+		journal.Set(fmt.Sprint(written%10), written)
+		journal.Lock()
+		rewriting = journal.rewriting
+		journal.Unlock()
+	}
+	for i := 0; i < 20; i++ {
+		journal.Set(fmt.Sprint("during", i), i)
+		written++
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rewriting := true; rewriting; {
+		if time.Now().After(deadline) {
+			t.Fatal("journal rewrite did not finish")
+		}
+		time.Sleep(time.Millisecond)
+		journal.Lock()
+		rewriting = journal.rewriting
+		journal.Unlock()
+	}
+	journal.Set("after", "value")
+	written++
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(contents), "\n"); lines >= written {
+		t.Errorf("journal has %v entries after %v writes; expected it to be compacted", lines, written)
+	}
+	if matches, _ := filepath.Glob(path + ".*.tmp"); len(matches) != 0 {
+		t.Errorf("temporary files were left behind: %v", matches)
+	}
+
+	journal = openTestJournal(t, path, 0)
+	defer journal.Close()
+	for i := 0; i < 20; i++ {
+		if value, err := journal.Get(fmt.Sprint("during", i)); err != nil || value != float64(i) {
+			t.Errorf("during%v = %v, %v; expected %v", i, value, err, i)
+		}
+	}
+	if _, err := journal.Get("after"); err != nil {
+		t.Errorf("write after the rewrite was lost: %v", err)
+	}
+}
+
+func TestJournalCloseTwice(t *testing.T) {
+	journal := openTestJournal(t, filepath.Join(t.TempDir(), "journal"), 0)
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("second Close returned %v; expected %v", err, os.ErrClosed)
+	}
+}
+
+func TestJournalCloseDuringRewrite(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		path := filepath.Join(t.TempDir(), "journal")
+		journal := openTestJournal(t, path, 0)
+		for j := 0; j < 100; j++ {
+			journal.Set(fmt.Sprint(j), j)
+		}
+
+		rewritten := make(chan error)
+		go func() { rewritten <- journal.Rewrite() }()
+		if err := journal.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// The rewrite either finished before Close, which then closed the new
+		// log, or it was discarded.
+		if err := <-rewritten; err != nil && !errors.Is(err, os.ErrClosed) {
+			t.Fatal(err)
+		}
+
+		if _, err := journal.file.Stat(); !errors.Is(err, os.ErrClosed) {
+			t.Fatalf("the log was left open after Close: %v", err)
+		}
+		if matches, _ := filepath.Glob(path + ".*.tmp"); len(matches) != 0 {
+			t.Fatalf("temporary files were left behind: %v", matches)
+		}
+		if err := journal.Rewrite(); !errors.Is(err, os.ErrClosed) {
+			t.Fatalf("rewrite after Close returned %v; expected %v", err, os.ErrClosed)
+		}
+
+		journal = openTestJournal(t, path, 0)
+		if info, _ := journal.Info(); info["size"] != uint64(100) {
+			t.Fatalf("%v items replayed after Close; expected 100", info["size"])
+		}
+		journal.Close()
+	}
+}
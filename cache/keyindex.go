@@ -0,0 +1,100 @@
+package cache
+
+import "math/rand"
+
+const keyIndexMaxLevel = 24
+
+// keyIndex keeps the keys of a cache in lexicographical order, so that Scan
+// can seek to its cursor instead of sorting every key for each page. It is a
+// skip list: inserting, removing and seeking take logarithmic time on
+// average. The zero value is an empty index.
+type keyIndex struct {
+	head   keyIndexNode
+	level  int
+	random *rand.Rand
+}
+
+type keyIndexNode struct {
+	key  string
+	next []*keyIndexNode
+}
+
+// randomLevel returns the number of levels of a new node, each level being
+// kept with a probability of one in four.
+func (ki *keyIndex) randomLevel() int {
+	if ki.random == nil {
+		ki.random = rand.New(rand.NewSource(rand.Int63()))
+	}
+	level := 1
+	for level < keyIndexMaxLevel && ki.random.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// predecessors returns, for every level, the last node whose key is lower
+// than the given one.
+func (ki *keyIndex) predecessors(key string) [keyIndexMaxLevel]*keyIndexNode {
+	var update [keyIndexMaxLevel]*keyIndexNode
+	node := &ki.head
+	for level := ki.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		update[level] = node
+	}
+	return update
+}
+
+func (ki *keyIndex) insert(key string) {
+	if ki.head.next == nil {
+		ki.head.next = make([]*keyIndexNode, keyIndexMaxLevel)
+	}
+	update := ki.predecessors(key)
+	if next := update[0]; ki.level > 0 && next.next[0] != nil && next.next[0].key == key {
+		return
+	}
+
+	level := ki.randomLevel()
+	for ki.level < level {
+		update[ki.level] = &ki.head
+		ki.level++
+	}
+	node := &keyIndexNode{key: key, next: make([]*keyIndexNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+}
+
+func (ki *keyIndex) remove(key string) {
+	if ki.level == 0 {
+		return
+	}
+	update := ki.predecessors(key)
+	node := update[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+	for i := range node.next {
+		update[i].next[i] = node.next[i]
+	}
+	for ki.level > 0 && ki.head.next[ki.level-1] == nil {
+		ki.level--
+	}
+}
+
+// seek returns the first node whose key is greater than or equal to the
+// given one, or nil if there is none. The following keys are reached through
+// next[0].
+func (ki *keyIndex) seek(key string) *keyIndexNode {
+	if ki.level == 0 {
+		return nil
+	}
+	return ki.predecessors(key)[0].next[0]
+}
+
+func (ki *keyIndex) contains(key string) bool {
+	node := ki.seek(key)
+	return node != nil && node.key == key
+}
@@ -3,83 +3,232 @@ package cache
 import (
 	"container/list"
 	"fmt"
+	"io"
+	"regexp"
+	"sort"
 	"time"
 )
 
+// AgingPolicy selects how the LFU cache lets the frequencies of items that
+// are no longer used fade away, so that they do not keep newer items out.
+type AgingPolicy string
+
+const (
+	NoAging      AgingPolicy = "none"
+	DecayAging   AgingPolicy = "decay"
+	DynamicAging AgingPolicy = "dynamic"
+)
+
+func (ap *AgingPolicy) Set(value string) error {
+	switch value {
+	case "none", "decay", "dynamic":
+		*ap = AgingPolicy(value)
+		return nil
+	default:
+		return fmt.Errorf("parse error")
+	}
+}
+
+func (ap *AgingPolicy) String() string {
+	return string(*ap)
+}
+
 type LFUCache struct {
 	cacheInfo
 	frequencyList *list.List
 	items         map[string]*LFUCacheItem
+	aging         AgingPolicy
+	agingFactor   float64
+	cacheAge      float64
 }
 
+// FrequencyListItem holds the items sharing a frequency, ordered from the
+// most to the least recently used, so that the least recently used one is
+// evicted first.
 type FrequencyListItem struct {
 	value           uint64
-	associatedItems map[string]struct{}
+	associatedItems *list.List
 }
 
 type LFUCacheItem struct {
 	*cacheItem
 	frequencyIndicator *list.Element
+	recencyIndicator   *list.Element
 }
 
 func (c *LFUCache) Set(key string, value interface{}, timeToLive ...time.Duration) (err error) {
 	c.Lock()
 	defer c.Unlock()
 
+	return c.set(key, value, nil, timeToLive...)
+}
+
+func (c *LFUCache) SetItem(item Item) (err error) {
+	return c.setItem(c, item)
+}
+
+func (c *LFUCache) SetMany(items []Item) (errs []error) {
+	return c.setMany(c, items)
+}
+
+func (c *LFUCache) set(key string, value interface{}, tags []string, timeToLive ...time.Duration) (err error) {
+	itemSize := estimateSize(key, value) + estimateTagsSize(tags)
+	if !c.fits(itemSize) {
+		return ErrItemTooLarge
+	}
+
 	item, ok := c.items[key]
 	if ok {
 		item.value = value
+		c.memoryUsage = c.memoryUsage - item.size + itemSize
+		item.size = itemSize
+
+		item.frequencyIndicator.Value.(*FrequencyListItem).associatedItems.MoveToFront(item.recencyIndicator)
+		for c.exceedsMemory(0) {
+			c.removeBackItem(key)
+		}
 	} else {
-		if c.capacity != 0 && c.size == c.capacity {
-			c.removeBackItems()
+		for c.size > 0 && c.isFull(itemSize) {
+			c.removeBackItem("")
 		}
 
-		item = &LFUCacheItem{cacheItem: &cacheItem{value: value}}
+		item = &LFUCacheItem{cacheItem: &cacheItem{key: key, value: value, size: itemSize}}
 		c.items[key] = item
-		c.size++
-
-		if c.frequencyList.Len() == 0 {
-			c.frequencyList.PushBack(&FrequencyListItem{})
-		}
+		c.addItem(item.cacheItem)
 
+		// New items start at the cache age, which is never above the lowest
+		// frequency, so they always belong at the back of the list.
+		initialFrequency := uint64(c.cacheAge)
 		frequencyListBackElement := c.frequencyList.Back()
-		frequencyListItem := frequencyListBackElement.Value.(*FrequencyListItem)
-		if frequencyListItem == nil {
-			frequencyListItem = &FrequencyListItem{value: 0}
-		} else if frequencyListItem.value != 0 {
-			newFrequencyListItem := &FrequencyListItem{value: 0}
-			frequencyListBackElement = c.frequencyList.PushBack(newFrequencyListItem)
-			frequencyListItem = newFrequencyListItem
-		}
-
-		if frequencyListItem.associatedItems == nil {
-			frequencyListItem.associatedItems = make(map[string]struct{})
+		if frequencyListBackElement == nil || frequencyListBackElement.Value.(*FrequencyListItem).value != initialFrequency {
+			frequencyListBackElement = c.frequencyList.PushBack(&FrequencyListItem{
+				value:           initialFrequency,
+				associatedItems: &list.List{},
+			})
 		}
 
-		frequencyListItem.associatedItems[key] = struct{}{}
 		item.frequencyIndicator = frequencyListBackElement
+		item.recencyIndicator = frequencyListBackElement.Value.(*FrequencyListItem).associatedItems.PushFront(item)
 	}
 
-	if len(timeToLive) == 1 && timeToLive[0] != 0 {
-		item.expirationTime = time.Now().Add(timeToLive[0])
-	} else if c.defaultTtl != 0 {
-		item.expirationTime = time.Now().Add(time.Duration(c.defaultTtl))
-	} else {
-		item.expirationTime = time.Time{}
+	c.updateItem(item.cacheItem, tags, timeToLive...)
+
+	return nil
+}
+
+// removeBackItem evicts the least recently used of the least frequently used
+// items, sparing the item identified by protectedKey.
+func (c *LFUCache) removeBackItem(protectedKey string) {
+	for element := c.frequencyList.Back(); element != nil; element = element.Prev() {
+		frequencyListItem := element.Value.(*FrequencyListItem)
+		for itemElement := frequencyListItem.associatedItems.Back(); itemElement != nil; itemElement = itemElement.Prev() {
+			if item := itemElement.Value.(*LFUCacheItem); item.key != protectedKey {
+				c.removeCacheItem(item, item.key)
+				c.age(frequencyListItem.value)
+				return
+			}
+		}
+	}
+}
+
+// SetAging sets the aging policy of the cache. With DecayAging, every
+// frequency is multiplied by factor each time the frequencies decay, which
+// must be at least 0 and below 1. With DynamicAging (LFU-DA), new items start
+// at the cache age instead of 0, and each eviction moves the cache age by
+// factor of the way towards the frequency of the evicted item, so a factor of
+// 1 gives the original LFU-DA and smaller ones age the cache more slowly.
+func (c *LFUCache) SetAging(policy AgingPolicy, factor float64) (err error) {
+	switch {
+	case policy == DecayAging && (factor < 0 || factor >= 1):
+		return fmt.Errorf("invalid decay factor %v: must be at least 0 and below 1", factor)
+	case policy == DynamicAging && (factor <= 0 || factor > 1):
+		return fmt.Errorf("invalid aging factor %v: must be above 0 and at most 1", factor)
+	case policy != NoAging && policy != DecayAging && policy != DynamicAging:
+		return fmt.Errorf("invalid value \"%v\" for aging policy", policy)
 	}
 
+	c.Lock()
+	defer c.Unlock()
+
+	c.aging = policy
+	c.agingFactor = factor
+	if policy != DynamicAging {
+		c.cacheAge = 0
+	}
 	return nil
 }
 
-func (c *LFUCache) removeBackItems() {
-	frequencyListBackElement := c.frequencyList.Back()
-	if frequencyListBackElement != nil {
-		frequencyListItem := frequencyListBackElement.Value.(*FrequencyListItem)
-		for item := range frequencyListItem.associatedItems {
-			delete(c.items, item)
-			c.size--
+// age raises the cache age after an item with the given frequency has been
+// evicted. It is kept at or below the lowest frequency still in the cache,
+// which may be that of an item spared by the eviction.
+func (c *LFUCache) age(evictedFrequency uint64) {
+	if c.aging != DynamicAging {
+		return
+	}
+
+	if evictedFrequency > uint64(c.cacheAge) {
+		c.cacheAge += (float64(evictedFrequency) - c.cacheAge) * c.agingFactor
+	}
+	if element := c.frequencyList.Back(); element != nil && element.Value.(*FrequencyListItem).value < uint64(c.cacheAge) {
+		c.cacheAge = float64(element.Value.(*FrequencyListItem).value)
+	}
+}
+
+// decay multiplies every frequency by the decay factor. Scaling keeps the
+// frequencies in order, so the list is updated in place, and only buckets
+// that end up with the frequency of their neighbour are merged into it, in
+// order of their last access.
+func (c *LFUCache) decay() {
+	for element := c.frequencyList.Front(); element != nil; {
+		next := element.Next()
+		frequencyListItem := element.Value.(*FrequencyListItem)
+		frequencyListItem.value = uint64(float64(frequencyListItem.value) * c.agingFactor)
+
+		if previous := element.Prev(); previous != nil && previous.Value.(*FrequencyListItem).value == frequencyListItem.value {
+			c.mergeFrequencyListItems(previous, element)
+		}
+		element = next
+	}
+}
+
+// mergeFrequencyListItems moves the items of the source element into the
+// target element and removes the source element.
+func (c *LFUCache) mergeFrequencyListItems(target *list.Element, source *list.Element) {
+	targetItems := target.Value.(*FrequencyListItem).associatedItems
+	position := targetItems.Front()
+	for itemElement := source.Value.(*FrequencyListItem).associatedItems.Front(); itemElement != nil; itemElement = itemElement.Next() {
+		item := itemElement.Value.(*LFUCacheItem)
+		for position != nil && position.Value.(*LFUCacheItem).lastAccess.After(item.lastAccess) {
+			position = position.Next()
 		}
-		c.frequencyList.Remove(frequencyListBackElement)
+
+		if position == nil {
+			item.recencyIndicator = targetItems.PushBack(item)
+		} else {
+			item.recencyIndicator = targetItems.InsertBefore(item, position)
+		}
+		item.frequencyIndicator = target
+	}
+	c.frequencyList.Remove(source)
+}
+
+// DecayFrequencies periodically decays the frequencies of the items when the
+// cache uses DecayAging, until stop is closed.
+func (c *LFUCache) DecayFrequencies(timeInterval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(timeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		c.Lock()
+		if c.aging == DecayAging {
+			c.decay()
+		}
+		c.Unlock()
 	}
 }
 
@@ -87,6 +236,22 @@ func (c *LFUCache) Get(key string) (value interface{}, err error) {
 	c.Lock()
 	defer c.Unlock()
 
+	item, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.value, nil
+}
+
+func (c *LFUCache) GetItem(key string) (item Item, err error) {
+	return c.getItem(c, key)
+}
+
+func (c *LFUCache) GetMany(keys []string) (items []Item, errs []error) {
+	return c.getMany(c, keys)
+}
+
+func (c *LFUCache) get(key string) (item *cacheItem, err error) {
 	if item, ok := c.items[key]; ok {
 		if !item.expirationTime.IsZero() && time.Now().After(item.expirationTime) {
 			c.removeCacheItem(item, key)
@@ -95,8 +260,9 @@ func (c *LFUCache) Get(key string) (value interface{}, err error) {
 		}
 
 		c.incrementItemFrequency(item, key)
+		item.lastAccess = time.Now()
 
-		return item.value, nil
+		return item.cacheItem, nil
 	} else {
 		return nil, fmt.Errorf("item does not exist")
 	}
@@ -106,13 +272,13 @@ func (c *LFUCache) removeCacheItem(item *LFUCacheItem, key string) {
 	frequencyListElement := item.frequencyIndicator
 	frequencyListItem := frequencyListElement.Value.(*FrequencyListItem)
 
-	delete(frequencyListItem.associatedItems, key)
-	if len(frequencyListItem.associatedItems) == 0 {
+	frequencyListItem.associatedItems.Remove(item.recencyIndicator)
+	if frequencyListItem.associatedItems.Len() == 0 {
 		c.frequencyList.Remove(frequencyListElement)
 	}
 
+	c.removeItem(item.cacheItem)
 	delete(c.items, key)
-	c.size--
 }
 
 func (c *LFUCache) incrementItemFrequency(item *LFUCacheItem, key string) {
@@ -124,17 +290,21 @@ func (c *LFUCache) incrementItemFrequency(item *LFUCacheItem, key string) {
 		nextFrequencyListItem *FrequencyListItem
 		ok                    bool
 	)
-	if currentFrequencyListElement.Next() != nil {
-		nextFrequencyListItem, ok = currentFrequencyListElement.Next().Value.(*FrequencyListItem)
+	if currentFrequencyListElement.Prev() != nil {
+		nextFrequencyListItem, ok = currentFrequencyListElement.Prev().Value.(*FrequencyListItem)
 	}
 
 	if !ok || nextFrequencyListItem.value != newFrequencyValue {
-		newFrequencyListItem := &FrequencyListItem{value: newFrequencyValue}
-		newFrequencyListItem.associatedItems = make(map[string]struct{})
-		newFrequencyListItem.associatedItems[key] = struct{}{}
-		c.frequencyList.InsertAfter(newFrequencyListItem, currentFrequencyListElement)
+		nextFrequencyListItem = &FrequencyListItem{value: newFrequencyValue, associatedItems: &list.List{}}
+		item.frequencyIndicator = c.frequencyList.InsertBefore(nextFrequencyListItem, currentFrequencyListElement)
 	} else {
-		nextFrequencyListItem.associatedItems[key] = struct{}{}
+		item.frequencyIndicator = currentFrequencyListElement.Prev()
+	}
+
+	currentFrequencyListItem.associatedItems.Remove(item.recencyIndicator)
+	item.recencyIndicator = nextFrequencyListItem.associatedItems.PushFront(item)
+	if currentFrequencyListItem.associatedItems.Len() == 0 {
+		c.frequencyList.Remove(currentFrequencyListElement)
 	}
 }
 
@@ -142,44 +312,156 @@ func (c *LFUCache) Delete(key string) (err error) {
 	c.Lock()
 	defer c.Unlock()
 
+	return c.delete(c, key)
+}
+
+func (c *LFUCache) DeleteMany(keys []string) (errs []error) {
+	return c.deleteMany(c, keys)
+}
+
+func (c *LFUCache) remove(key string) {
 	if item, ok := c.items[key]; ok {
 		c.removeCacheItem(item, key)
-		return nil
-	} else {
-		return fmt.Errorf("item does not exist")
 	}
 }
 
+func (c *LFUCache) DeleteMatching(pattern *regexp.Regexp) (keys []string, err error) {
+	return c.deleteMatching(c, pattern), nil
+}
+
+func (c *LFUCache) DeleteTagged(tag string) (keys []string, err error) {
+	return c.deleteTagged(c, tag), nil
+}
+
 func (c *LFUCache) Purge() (err error) {
 	c.Lock()
 	defer c.Unlock()
 
 	c.frequencyList = &list.List{}
 	c.items = make(map[string]*LFUCacheItem)
-	c.size = 0
+	c.cacheAge = 0
+	c.clear()
 	return nil
 }
 
-func (c *LFUCache) DeleteExpired(timeInterval time.Duration) {
-	ticker := time.NewTicker(timeInterval)
-	defer ticker.Stop()
+func (c *LFUCache) DeleteExpired(timeInterval time.Duration, stop <-chan struct{}) {
+	c.deleteExpired(c, timeInterval, stop)
+}
 
-	for {
-		<-ticker.C
-		c.Lock()
-		for key, item := range c.items {
-			if !item.expirationTime.IsZero() && time.Now().After(item.expirationTime) {
-				c.removeCacheItem(item, key)
-			}
-		}
-		c.Unlock()
+func (c *LFUCache) Scan(prefix string, cursor string, limit int) (keys []KeyInfo, nextCursor string, err error) {
+	keys, nextCursor = c.scan(c, prefix, cursor, limit)
+	return keys, nextCursor, nil
+}
+
+func (c *LFUCache) forEachItem(visit func(item *cacheItem)) {
+	for _, item := range c.items {
+		visit(item.cacheItem)
+	}
+}
+
+func (c *LFUCache) keyInfo(key string, now time.Time) (info KeyInfo, ok bool) {
+	item, ok := c.items[key]
+	if !ok || item.isExpired(now) {
+		return KeyInfo{}, false
 	}
+	keyInfo := newKeyInfo(item.cacheItem, now)
+	keyInfo.Frequency = item.frequencyIndicator.Value.(*FrequencyListItem).value
+	return keyInfo, true
 }
 
 func (c *LFUCache) Info() (info map[string]interface{}, err error) {
-	info = make(map[string]interface{})
-	info["size"] = c.size
-	info["capacity"] = c.capacity
-	info["defaultTtl"] = c.defaultTtl
+	c.RLock()
+	defer c.RUnlock()
+
+	info = c.info()
+	info["aging"] = c.aging
+	if c.aging == DynamicAging {
+		info["cacheAge"] = uint64(c.cacheAge)
+	}
 	return info, nil
 }
+
+func (c *LFUCache) Resize(capacity uint64, maxMemory uint64) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.capacity = capacity
+	c.maxMemory = maxMemory
+	for c.size > 0 && c.isOverLimit() {
+		c.removeBackItem("")
+	}
+	return nil
+}
+
+func (c *LFUCache) Save(w io.Writer) (err error) {
+	c.RLock()
+	items := make([]snapshotItem, 0, c.size)
+	for listElement := c.frequencyList.Front(); listElement != nil; listElement = listElement.Next() {
+		frequencyListItem := listElement.Value.(*FrequencyListItem)
+		for itemElement := frequencyListItem.associatedItems.Front(); itemElement != nil; itemElement = itemElement.Next() {
+			item := itemElement.Value.(*LFUCacheItem)
+			items = append(items, snapshotItem{
+				Key:            item.key,
+				Value:          item.value,
+				ExpirationTime: item.expirationTime,
+				Frequency:      frequencyListItem.value,
+				Tags:           item.tags,
+			})
+		}
+	}
+	c.RUnlock()
+
+	return writeSnapshot(w, LFU, items)
+}
+
+func (c *LFUCache) Load(r io.Reader) (err error) {
+	s, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(s.Items, func(i, j int) bool {
+		return s.Items[i].Frequency > s.Items[j].Frequency
+	})
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.frequencyList = &list.List{}
+	c.items = make(map[string]*LFUCacheItem)
+	c.cacheAge = 0
+	c.clear()
+	c.load(c, s.Items)
+
+	// The cache age is not saved, so it restarts as if the least frequently
+	// used of the restored items had just been evicted.
+	if element := c.frequencyList.Back(); element != nil && c.aging == DynamicAging {
+		c.age(element.Value.(*FrequencyListItem).value)
+	}
+
+	return nil
+}
+
+// restore receives the items from the most to the least frequently used, so
+// once the cache is full the remaining items are dropped instead of evicting
+// the ones that were already restored.
+func (c *LFUCache) restore(cacheItem *cacheItem, frequency uint64) {
+	if c.isFull(cacheItem.size) {
+		return
+	}
+
+	frequencyListBackElement := c.frequencyList.Back()
+	if frequencyListBackElement == nil || frequencyListBackElement.Value.(*FrequencyListItem).value != frequency {
+		frequencyListBackElement = c.frequencyList.PushBack(&FrequencyListItem{
+			value:           frequency,
+			associatedItems: &list.List{},
+		})
+	}
+
+	item := &LFUCacheItem{cacheItem: cacheItem, frequencyIndicator: frequencyListBackElement}
+	// Items sharing a frequency are saved from the most to the least recently
+	// used, and the sort in Load keeps them in that order.
+	item.recencyIndicator = frequencyListBackElement.Value.(*FrequencyListItem).associatedItems.PushBack(item)
+	c.items[item.key] = item
+	c.tagItem(item.cacheItem)
+	c.addItem(item.cacheItem)
+}
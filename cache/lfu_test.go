@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"testing/quick"
+)
+
+// lfuModel tracks the frequency and the last access of every key the way the
+// LFU cache is expected to, without sharing any of its data structures.
+type lfuModel struct {
+	aging     AgingPolicy
+	factor    float64
+	capacity  uint64
+	frequency map[string]uint64
+	accessed  map[string]int
+	clock     int
+	cacheAge  float64
+}
+
+func newLFUModel(capacity uint64, aging AgingPolicy, factor float64) *lfuModel {
+	return &lfuModel{
+		aging:     aging,
+		factor:    factor,
+		capacity:  capacity,
+		frequency: make(map[string]uint64),
+		accessed:  make(map[string]int),
+	}
+}
+
+func (m *lfuModel) access(key string) {
+	m.clock++
+	m.accessed[key] = m.clock
+}
+
+// victim returns the least recently used of the least frequently used keys.
+func (m *lfuModel) victim() string {
+	victim := ""
+	for key, frequency := range m.frequency {
+		if victim == "" || frequency < m.frequency[victim] ||
+			(frequency == m.frequency[victim] && m.accessed[key] < m.accessed[victim]) {
+			victim = key
+		}
+	}
+	return victim
+}
+
+func (m *lfuModel) evict() string {
+	victim := m.victim()
+	frequency := m.frequency[victim]
+	delete(m.frequency, victim)
+	delete(m.accessed, victim)
+
+	if m.aging == DynamicAging {
+		if frequency > uint64(m.cacheAge) {
+			m.cacheAge += (float64(frequency) - m.cacheAge) * m.factor
+		}
+		if len(m.frequency) != 0 && m.frequency[m.victim()] < uint64(m.cacheAge) {
+			m.cacheAge = float64(m.frequency[m.victim()])
+		}
+	}
+	return victim
+}
+
+func (m *lfuModel) set(key string) (evicted []string) {
+	if _, ok := m.frequency[key]; !ok {
+		for uint64(len(m.frequency)) >= m.capacity {
+			evicted = append(evicted, m.evict())
+		}
+		m.frequency[key] = uint64(m.cacheAge)
+	}
+	m.access(key)
+	return evicted
+}
+
+func (m *lfuModel) get(key string) {
+	if _, ok := m.frequency[key]; ok {
+		m.frequency[key]++
+		m.access(key)
+	}
+}
+
+func (m *lfuModel) resize(capacity uint64) (evicted []string) {
+	m.capacity = capacity
+	for uint64(len(m.frequency)) > m.capacity {
+		evicted = append(evicted, m.evict())
+	}
+	return evicted
+}
+
+func (m *lfuModel) decay() {
+	for key, frequency := range m.frequency {
+		m.frequency[key] = uint64(float64(frequency) * m.factor)
+	}
+}
+
+func cacheKeys(c *LFUCache) map[string]struct{} {
+	keys := make(map[string]struct{}, len(c.items))
+	for key := range c.items {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+func disappeared(before map[string]struct{}, c *LFUCache) []string {
+	keys := make([]string, 0)
+	for key := range before {
+		if _, ok := c.items[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runLFUSequence applies a random sequence of operations derived from the
+// seed to both the cache and the model, and checks after each of them that
+// the cache stays within its capacity and evicts the same keys as the model.
+func runLFUSequence(seed int64, aging AgingPolicy, factor float64) error {
+	random := rand.New(rand.NewSource(seed))
+	capacity := uint64(1 + random.Intn(16))
+
+	newCache, err := New(capacity, 0, LFU, 0)
+	if err != nil {
+		return err
+	}
+	c := newCache.(*LFUCache)
+	if err := c.SetAging(aging, factor); err != nil {
+		return err
+	}
+	m := newLFUModel(capacity, aging, factor)
+
+	for step := 0; step < 2000; step++ {
+		key := fmt.Sprint(random.Intn(3 * int(m.capacity)))
+		before := cacheKeys(c)
+
+		var expected []string
+		operation := random.Intn(20)
+		switch {
+		case operation < 9:
+			overflows := uint64(len(before)) >= m.capacity
+			if _, ok := before[key]; ok {
+				overflows = false
+			}
+
+			expected = m.set(key)
+			if err := c.Set(key, step); err != nil {
+				return err
+			}
+			if overflows && len(disappeared(before, c)) != 1 {
+				return fmt.Errorf("step %v: set %q evicted %v, expected exactly one key", step, key, disappeared(before, c))
+			}
+		case operation < 17:
+			m.get(key)
+			c.Get(key)
+		case operation < 18:
+			delete(m.frequency, key)
+			delete(m.accessed, key)
+			c.Delete(key)
+			delete(before, key)
+		case operation < 19:
+			capacity := uint64(1 + random.Intn(16))
+			expected = m.resize(capacity)
+			if err := c.Resize(capacity, 0); err != nil {
+				return err
+			}
+		default:
+			if aging == DecayAging {
+				m.decay()
+				c.Lock()
+				c.decay()
+				c.Unlock()
+			}
+		}
+
+		sort.Strings(expected)
+		if evicted := disappeared(before, c); fmt.Sprint(evicted) != fmt.Sprint(expected) {
+			return fmt.Errorf("step %v: evicted %v, expected %v", step, evicted, expected)
+		}
+		if c.size > c.capacity {
+			return fmt.Errorf("step %v: size %v exceeds capacity %v", step, c.size, c.capacity)
+		}
+		if c.size != uint64(len(c.items)) || len(c.items) != len(m.frequency) {
+			return fmt.Errorf("step %v: size %v, %v items, expected %v", step, c.size, len(c.items), len(m.frequency))
+		}
+		for key, frequency := range m.frequency {
+			item, ok := c.items[key]
+			if !ok {
+				return fmt.Errorf("step %v: missing key %q", step, key)
+			}
+			if value := item.frequencyIndicator.Value.(*FrequencyListItem).value; value != frequency {
+				return fmt.Errorf("step %v: key %q has frequency %v, expected %v", step, key, value, frequency)
+			}
+		}
+	}
+	return nil
+}
+
+func TestLFUEvictsOneLeastRecentlyUsedItem(t *testing.T) {
+	tests := []struct {
+		aging  AgingPolicy
+		factor float64
+	}{
+		{NoAging, 0},
+		{DecayAging, 0.5},
+		{DecayAging, 0},
+		{DynamicAging, 1},
+		{DynamicAging, 0.5},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v/%v", test.aging, test.factor), func(t *testing.T) {
+			property := func(seed int64) bool {
+				if err := runLFUSequence(seed, test.aging, test.factor); err != nil {
+					t.Logf("seed %v: %v", seed, err)
+					return false
+				}
+				return true
+			}
+			if err := quick.Check(property, &quick.Config{MaxCount: 50}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package cache
 import (
 	"container/list"
 	"fmt"
+	"io"
+	"regexp"
 	"time"
 )
 
@@ -16,55 +18,88 @@ func (c *LRUCache) Set(key string, value interface{}, timeToLive ...time.Duratio
 	c.Lock()
 	defer c.Unlock()
 
+	return c.set(key, value, nil, timeToLive...)
+}
+
+func (c *LRUCache) SetItem(item Item) (err error) {
+	return c.setItem(c, item)
+}
+
+func (c *LRUCache) SetMany(items []Item) (errs []error) {
+	return c.setMany(c, items)
+}
+
+func (c *LRUCache) set(key string, value interface{}, tags []string, timeToLive ...time.Duration) (err error) {
+	itemSize := estimateSize(key, value) + estimateTagsSize(tags)
+	if !c.fits(itemSize) {
+		return ErrItemTooLarge
+	}
+
 	var item *cacheItem
 	if listElement, ok := c.items[key]; ok {
 		c.positionList.MoveToFront(listElement)
 		item = listElement.Value.(*cacheItem)
 		item.value = value
+		c.memoryUsage = c.memoryUsage - item.size + itemSize
+		item.size = itemSize
+
+		for c.exceedsMemory(0) {
+			c.removeBackElement()
+		}
 	} else {
-		if c.capacity != 0 && c.size == c.capacity {
+		for c.size > 0 && c.isFull(itemSize) {
 			c.removeBackElement()
 		}
 
-		item = &cacheItem{value: value}
+		item = &cacheItem{key: key, value: value, size: itemSize}
 		c.items[key] = c.positionList.PushFront(item)
-		c.size++
+		c.addItem(item)
 	}
 
-	if len(timeToLive) == 1 && timeToLive[0] != 0 {
-		item.expirationTime = time.Now().Add(timeToLive[0])
-	} else if c.defaultTtl != 0 {
-		item.expirationTime = time.Now().Add(time.Duration(c.defaultTtl))
-	} else {
-		item.expirationTime = time.Time{}
-	}
+	c.updateItem(item, tags, timeToLive...)
 
 	return nil
 }
 
 func (c *LRUCache) removeBackElement() {
 	if listElement := c.positionList.Back(); listElement != nil {
-		c.positionList.Remove(listElement)
+		c.removeCacheItem(listElement, listElement.Value.(*cacheItem).key)
 	}
 }
 
 func (c *LRUCache) Get(key string) (value interface{}, err error) {
-	c.RLock()
-	defer c.RUnlock()
+	c.Lock()
+	defer c.Unlock()
 
+	item, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.value, nil
+}
+
+func (c *LRUCache) GetItem(key string) (item Item, err error) {
+	return c.getItem(c, key)
+}
+
+func (c *LRUCache) GetMany(keys []string) (items []Item, errs []error) {
+	return c.getMany(c, keys)
+}
+
+func (c *LRUCache) get(key string) (item *cacheItem, err error) {
 	if listElement, ok := c.items[key]; ok {
-		c.positionList.MoveToFront(listElement)
 		item := listElement.Value.(*cacheItem)
 
 		if !item.expirationTime.IsZero() && time.Now().After(item.expirationTime) {
-			c.Lock()
 			c.removeCacheItem(listElement, key)
-			c.Unlock()
 
 			return nil, fmt.Errorf("item does not exist")
 		}
 
-		return item.value, nil
+		c.positionList.MoveToFront(listElement)
+		item.lastAccess = time.Now()
+
+		return item, nil
 	} else {
 		return nil, fmt.Errorf("item does not exist")
 	}
@@ -74,51 +109,124 @@ func (c *LRUCache) Delete(key string) (err error) {
 	c.Lock()
 	defer c.Unlock()
 
+	return c.delete(c, key)
+}
+
+func (c *LRUCache) DeleteMany(keys []string) (errs []error) {
+	return c.deleteMany(c, keys)
+}
+
+func (c *LRUCache) remove(key string) {
 	if listElement, ok := c.items[key]; ok {
 		c.removeCacheItem(listElement, key)
-		return nil
-	} else {
-		return fmt.Errorf("item does not exist")
 	}
 }
 
+func (c *LRUCache) DeleteMatching(pattern *regexp.Regexp) (keys []string, err error) {
+	return c.deleteMatching(c, pattern), nil
+}
+
+func (c *LRUCache) DeleteTagged(tag string) (keys []string, err error) {
+	return c.deleteTagged(c, tag), nil
+}
+
 func (c *LRUCache) Purge() (err error) {
 	c.Lock()
 	defer c.Unlock()
 
 	c.positionList = &list.List{}
 	c.items = make(map[string]*list.Element)
-	c.size = 0
+	c.clear()
 	return nil
 }
 
 func (c *LRUCache) removeCacheItem(listElement *list.Element, key string) {
 	c.positionList.Remove(listElement)
+	c.removeItem(listElement.Value.(*cacheItem))
 	delete(c.items, key)
-	c.size--
 }
 
-func (c *LRUCache) DeleteExpired(timeInterval time.Duration) {
-	ticker := time.NewTicker(timeInterval)
-	defer ticker.Stop()
+func (c *LRUCache) DeleteExpired(timeInterval time.Duration, stop <-chan struct{}) {
+	c.deleteExpired(c, timeInterval, stop)
+}
 
-	for {
-		<-ticker.C
-		c.Lock()
-		for key, listElement := range c.items {
-			item := listElement.Value.(*cacheItem)
-			if !item.expirationTime.IsZero() && time.Now().After(item.expirationTime) {
-				c.removeCacheItem(listElement, key)
-			}
-		}
-		c.Unlock()
+func (c *LRUCache) Scan(prefix string, cursor string, limit int) (keys []KeyInfo, nextCursor string, err error) {
+	keys, nextCursor = c.scan(c, prefix, cursor, limit)
+	return keys, nextCursor, nil
+}
+
+func (c *LRUCache) forEachItem(visit func(item *cacheItem)) {
+	for _, listElement := range c.items {
+		visit(listElement.Value.(*cacheItem))
 	}
 }
 
+func (c *LRUCache) keyInfo(key string, now time.Time) (info KeyInfo, ok bool) {
+	listElement, ok := c.items[key]
+	if !ok || listElement.Value.(*cacheItem).isExpired(now) {
+		return KeyInfo{}, false
+	}
+	return newKeyInfo(listElement.Value.(*cacheItem), now), true
+}
+
 func (c *LRUCache) Info() (info map[string]interface{}, err error) {
-	info = make(map[string]interface{})
-	info["size"] = c.size
-	info["capacity"] = c.capacity
-	info["defaultTtl"] = c.defaultTtl
-	return info, nil
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.info(), nil
+}
+
+func (c *LRUCache) Resize(capacity uint64, maxMemory uint64) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.capacity = capacity
+	c.maxMemory = maxMemory
+	for c.size > 0 && c.isOverLimit() {
+		c.removeBackElement()
+	}
+	return nil
+}
+
+func (c *LRUCache) Save(w io.Writer) (err error) {
+	c.RLock()
+	items := make([]snapshotItem, 0, c.size)
+	for listElement := c.positionList.Back(); listElement != nil; listElement = listElement.Prev() {
+		item := listElement.Value.(*cacheItem)
+		items = append(items, snapshotItem{
+			Key:            item.key,
+			Value:          item.value,
+			ExpirationTime: item.expirationTime,
+			Tags:           item.tags,
+		})
+	}
+	c.RUnlock()
+
+	return writeSnapshot(w, LRU, items)
+}
+
+func (c *LRUCache) Load(r io.Reader) (err error) {
+	s, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.positionList = &list.List{}
+	c.items = make(map[string]*list.Element)
+	c.clear()
+	c.load(c, s.Items)
+	return nil
+}
+
+func (c *LRUCache) restore(item *cacheItem, frequency uint64) {
+	for c.size > 0 && c.isFull(item.size) {
+		c.removeBackElement()
+	}
+
+	c.items[item.key] = c.positionList.PushFront(item)
+	c.tagItem(item)
+	c.addItem(item)
 }
@@ -2,42 +2,375 @@ package cache
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
+	"io"
+	"regexp"
 	"sync"
 	"time"
 )
 
+var ErrItemTooLarge = errors.New("item exceeds the memory limit")
+
 type cacheInfo struct {
-	size       uint64
-	capacity   uint64
-	defaultTtl time.Duration
+	size        uint64
+	capacity    uint64
+	memoryUsage uint64
+	maxMemory   uint64
+	defaultTtl  time.Duration
+	tags        map[string]map[string]struct{}
+	keys        keyIndex
+	// sharedReads is set by the policies that record hits with atomic
+	// operations, whose reads only take the read lock. Expired items found by
+	// such reads are left for the sweeper and for eviction.
+	sharedReads bool
 	sync.RWMutex
 }
 
+func (c *cacheInfo) isFull(additionalSize uint64) bool {
+	return (c.capacity != 0 && c.size >= c.capacity) || c.exceedsMemory(additionalSize)
+}
+
+func (c *cacheInfo) exceedsMemory(additionalSize uint64) bool {
+	return c.maxMemory != 0 && c.memoryUsage+additionalSize > c.maxMemory
+}
+
+func (c *cacheInfo) isOverLimit() bool {
+	return (c.capacity != 0 && c.size > c.capacity) || c.exceedsMemory(0)
+}
+
+func (c *cacheInfo) fits(itemSize uint64) bool {
+	return c.maxMemory == 0 || itemSize <= c.maxMemory
+}
+
+// addItem accounts for an item the policy has just stored.
+func (c *cacheInfo) addItem(item *cacheItem) {
+	c.keys.insert(item.key)
+	c.size++
+	c.memoryUsage += item.size
+}
+
+// removeItem accounts for an item the policy has just dropped, whether it was
+// deleted, evicted or expired.
+func (c *cacheInfo) removeItem(item *cacheItem) {
+	c.untagItem(item)
+	c.keys.remove(item.key)
+	c.size--
+	c.memoryUsage -= item.size
+}
+
+// clear accounts for the policy dropping every item at once.
+func (c *cacheInfo) clear() {
+	c.size = 0
+	c.memoryUsage = 0
+	c.tags = nil
+	c.keys = keyIndex{}
+}
+
+// tagItem adds the item to the index that maps each tag to the keys of the
+// items carrying it. Every item has to be removed from the index with
+// untagItem when it is deleted, evicted, expired or overwritten.
+func (c *cacheInfo) tagItem(item *cacheItem) {
+	if c.tags == nil {
+		c.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range item.tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][item.key] = struct{}{}
+	}
+}
+
+func (c *cacheInfo) untagItem(item *cacheItem) {
+	for _, tag := range item.tags {
+		delete(c.tags[tag], item.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+func (c *cacheInfo) taggedKeys(tag string) []string {
+	keys := make([]string, 0, len(c.tags[tag]))
+	for key := range c.tags[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// itemIndex gives the operations shared by every policy access to the items
+// of the cache, whatever structure holds them. Its methods are called with
+// the lock held. forEachItem has to allow removing the visited item, and
+// restore places an item read from a snapshot, evicting others as needed,
+// and accounts for it with tagItem and addItem unless the policy drops it.
+type itemIndex interface {
+	set(key string, value interface{}, tags []string, timeToLive ...time.Duration) (err error)
+	get(key string) (item *cacheItem, err error)
+	remove(key string)
+	forEachItem(visit func(item *cacheItem))
+	keyInfo(key string, now time.Time) (info KeyInfo, ok bool)
+	restore(item *cacheItem, frequency uint64)
+}
+
+func (c *cacheInfo) lockReads() {
+	if c.sharedReads {
+		c.RLock()
+	} else {
+		c.Lock()
+	}
+}
+
+func (c *cacheInfo) unlockReads() {
+	if c.sharedReads {
+		c.RUnlock()
+	} else {
+		c.Unlock()
+	}
+}
+
+func (c *cacheInfo) setItem(index itemIndex, item Item) error {
+	c.Lock()
+	defer c.Unlock()
+
+	return index.set(item.Key, item.Value, item.Tags, item.TimeToLive)
+}
+
+func (c *cacheInfo) setMany(index itemIndex, items []Item) []error {
+	c.Lock()
+	defer c.Unlock()
+
+	errs := make([]error, len(items))
+	for i, item := range items {
+		errs[i] = index.set(item.Key, item.Value, item.Tags, item.TimeToLive)
+	}
+	return errs
+}
+
+func (c *cacheInfo) getItem(index itemIndex, key string) (Item, error) {
+	c.lockReads()
+	defer c.unlockReads()
+
+	item, err := index.get(key)
+	if err != nil {
+		return Item{}, err
+	}
+	return item.toItem(time.Now()), nil
+}
+
+func (c *cacheInfo) getMany(index itemIndex, keys []string) ([]Item, []error) {
+	c.lockReads()
+	defer c.unlockReads()
+
+	now := time.Now()
+	items := make([]Item, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		var item *cacheItem
+		if item, errs[i] = index.get(key); errs[i] == nil {
+			items[i] = item.toItem(now)
+		}
+	}
+	return items, errs
+}
+
+func (c *cacheInfo) delete(index itemIndex, key string) error {
+	if !c.keys.contains(key) {
+		return fmt.Errorf("item does not exist")
+	}
+	index.remove(key)
+	return nil
+}
+
+func (c *cacheInfo) deleteMany(index itemIndex, keys []string) []error {
+	c.Lock()
+	defer c.Unlock()
+
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		errs[i] = c.delete(index, key)
+	}
+	return errs
+}
+
+// load restores the items of a snapshot into the emptied index, skipping the
+// expired items and the ones that can never fit.
+func (c *cacheInfo) load(index itemIndex, items []snapshotItem) {
+	now := time.Now()
+	for _, snapshotItem := range items {
+		itemSize := estimateSize(snapshotItem.Key, snapshotItem.Value) + estimateTagsSize(snapshotItem.Tags)
+		if c.keys.contains(snapshotItem.Key) || snapshotItem.isExpired(now) || !c.fits(itemSize) {
+			continue
+		}
+
+		index.restore(&cacheItem{
+			key:            snapshotItem.Key,
+			value:          snapshotItem.Value,
+			size:           itemSize,
+			expirationTime: snapshotItem.ExpirationTime,
+			lastAccess:     now,
+			tags:           snapshotItem.Tags,
+		}, snapshotItem.Frequency)
+	}
+}
+
+// updateItem finishes setting an item once the policy has placed it: it
+// replaces the tags of the item in the index and sets its expiration time
+// from the given time-to-live, or from the default one.
+func (c *cacheInfo) updateItem(item *cacheItem, tags []string, timeToLive ...time.Duration) {
+	c.untagItem(item)
+	item.tags = tags
+	c.tagItem(item)
+
+	item.lastAccess = time.Now()
+	if len(timeToLive) == 1 && timeToLive[0] != 0 {
+		item.expirationTime = time.Now().Add(timeToLive[0])
+	} else if c.defaultTtl != 0 {
+		item.expirationTime = time.Now().Add(time.Duration(c.defaultTtl))
+	} else {
+		item.expirationTime = time.Time{}
+	}
+}
+
+// deleteExpired removes the expired items of the index every time interval,
+// until stop is closed.
+func (c *cacheInfo) deleteExpired(index itemIndex, timeInterval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(timeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		c.Lock()
+		now := time.Now()
+		index.forEachItem(func(item *cacheItem) {
+			if item.isExpired(now) {
+				index.remove(item.key)
+			}
+		})
+		c.Unlock()
+	}
+}
+
+func (c *cacheInfo) deleteMatching(index itemIndex, pattern *regexp.Regexp) []string {
+	c.Lock()
+	defer c.Unlock()
+
+	keys := make([]string, 0)
+	index.forEachItem(func(item *cacheItem) {
+		if pattern.MatchString(item.key) {
+			keys = append(keys, item.key)
+			index.remove(item.key)
+		}
+	})
+	return keys
+}
+
+func (c *cacheInfo) deleteTagged(index itemIndex, tag string) []string {
+	c.Lock()
+	defer c.Unlock()
+
+	keys := c.taggedKeys(tag)
+	for _, key := range keys {
+		index.remove(key)
+	}
+	return keys
+}
+
+func (c *cacheInfo) info() map[string]interface{} {
+	info := make(map[string]interface{})
+	info["size"] = c.size
+	info["capacity"] = c.capacity
+	info["memoryUsage"] = c.memoryUsage
+	info["maxMemory"] = c.maxMemory
+	info["defaultTtl"] = c.defaultTtl
+	return info
+}
+
+func (c *cacheInfo) SetDefaultTtl(defaultTtl time.Duration) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.defaultTtl = defaultTtl
+	return nil
+}
+
 type cacheItem struct {
+	key            string
 	value          interface{}
+	size           uint64
 	expirationTime time.Time
+	lastAccess     time.Time
+	tags           []string
+}
+
+func (item *cacheItem) isExpired(now time.Time) bool {
+	return !item.expirationTime.IsZero() && now.After(item.expirationTime)
 }
 
+// toItem returns the item along with its remaining time-to-live.
+func (item *cacheItem) toItem(now time.Time) Item {
+	result := Item{Key: item.key, Value: item.value, Tags: item.tags}
+	if !item.expirationTime.IsZero() {
+		result.TimeToLive = item.expirationTime.Sub(now)
+	}
+	return result
+}
+
+// Item is an entry passed to SetItem and SetMany. A zero TimeToLive stands
+// for the default time-to-live of the cache. When returned by GetItem and
+// GetMany, TimeToLive is the remaining time-to-live instead.
+type Item struct {
+	Key        string
+	Value      interface{}
+	TimeToLive time.Duration
+	Tags       []string
+}
+
+// Cache is implemented by every eviction policy. The Many variants apply
+// their operation to each key in order while holding the lock once, and
+// return one value or error per key. Scan lists keys without affecting
+// their eviction order. DeleteMatching and DeleteTagged delete every key
+// matching the pattern or carrying the tag at once, and return the deleted
+// keys.
 type Cache interface {
 	Set(key string, value interface{}, timeToLive ...time.Duration) (err error)
 	Get(key string) (value interface{}, err error)
 	Delete(key string) (err error)
+	SetItem(item Item) (err error)
+	GetItem(key string) (item Item, err error)
+	SetMany(items []Item) (errs []error)
+	GetMany(keys []string) (items []Item, errs []error)
+	DeleteMany(keys []string) (errs []error)
+	DeleteMatching(pattern *regexp.Regexp) (keys []string, err error)
+	DeleteTagged(tag string) (keys []string, err error)
 	Purge() (err error)
-	DeleteExpired(timeInterval time.Duration)
+	DeleteExpired(timeInterval time.Duration, stop <-chan struct{})
+	Scan(prefix string, cursor string, limit int) (keys []KeyInfo, nextCursor string, err error)
 	Info() (info map[string]interface{}, err error)
+	Resize(capacity uint64, maxMemory uint64) (err error)
+	SetDefaultTtl(defaultTtl time.Duration) (err error)
+	Save(w io.Writer) (err error)
+	Load(r io.Reader) (err error)
 }
 
 type EvictionPolicy string
 
 const (
-	LRU EvictionPolicy = "LRU"
-	LFU EvictionPolicy = "LFU"
+	LRU     EvictionPolicy = "LRU"
+	LFU     EvictionPolicy = "LFU"
+	ARC     EvictionPolicy = "ARC"
+	TinyLFU EvictionPolicy = "TinyLFU"
+	SIEVE   EvictionPolicy = "SIEVE"
+	S3FIFO  EvictionPolicy = "S3-FIFO"
 )
 
 func (ep *EvictionPolicy) Set(value string) error {
 	switch value {
-	case "LRU", "LFU":
+	case "LRU", "LFU", "ARC", "TinyLFU", "SIEVE", "S3-FIFO":
 		*ep = EvictionPolicy(value)
 		return nil
 	default:
@@ -49,13 +382,14 @@ func (ep *EvictionPolicy) String() string {
 	return string(*ep)
 }
 
-func New(capacity uint64, evictionPolicy EvictionPolicy, defaultTtl time.Duration) (cache Cache, err error) {
+func New(capacity uint64, maxMemory uint64, evictionPolicy EvictionPolicy, defaultTtl time.Duration) (cache Cache, err error) {
 	switch {
 	case evictionPolicy == LRU:
 		return &LRUCache{
 			cacheInfo: cacheInfo{
 				size:       0,
 				capacity:   capacity,
+				maxMemory:  maxMemory,
 				defaultTtl: defaultTtl,
 			},
 			positionList: &list.List{},
@@ -66,11 +400,56 @@ func New(capacity uint64, evictionPolicy EvictionPolicy, defaultTtl time.Duratio
 			cacheInfo: cacheInfo{
 				size:       0,
 				capacity:   capacity,
+				maxMemory:  maxMemory,
 				defaultTtl: defaultTtl,
 			},
 			frequencyList: &list.List{},
 			items:         make(map[string]*LFUCacheItem),
+			aging:         NoAging,
+		}, nil
+	case evictionPolicy == ARC:
+		cache := &ARCCache{
+			cacheInfo: cacheInfo{
+				capacity:   capacity,
+				maxMemory:  maxMemory,
+				defaultTtl: defaultTtl,
+			},
+		}
+		cache.reset()
+		return cache, nil
+	case evictionPolicy == TinyLFU:
+		cache := &TinyLFUCache{
+			cacheInfo: cacheInfo{
+				capacity:   capacity,
+				maxMemory:  maxMemory,
+				defaultTtl: defaultTtl,
+			},
+		}
+		cache.reset()
+		return cache, nil
+	case evictionPolicy == SIEVE:
+		return &SIEVECache{
+			cacheInfo: cacheInfo{
+				size:        0,
+				capacity:    capacity,
+				maxMemory:   maxMemory,
+				defaultTtl:  defaultTtl,
+				sharedReads: true,
+			},
+			queue: &list.List{},
+			items: make(map[string]*list.Element),
 		}, nil
+	case evictionPolicy == S3FIFO:
+		cache := &S3FIFOCache{
+			cacheInfo: cacheInfo{
+				capacity:    capacity,
+				maxMemory:   maxMemory,
+				defaultTtl:  defaultTtl,
+				sharedReads: true,
+			},
+		}
+		cache.reset()
+		return cache, nil
 	default:
 		return nil, fmt.Errorf("invalid value \"%v\" for eviction policy", evictionPolicy)
 	}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var policies = []EvictionPolicy{LRU, LFU, ARC, TinyLFU, SIEVE, S3FIFO}
+
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// runOperations applies operations such as "set a" or "get a" to a cache,
+// storing every key as its own value.
+func runOperations(c Cache, operations []string) {
+	for _, operation := range operations {
+		var name, key string
+		fmt.Sscan(operation, &name, &key)
+		if name == "set" {
+			c.Set(key, key)
+		} else {
+			c.Get(key)
+		}
+	}
+}
+
+// TestSharedOperations runs the operations every policy shares through
+// cacheInfo, which only differ in how the items are stored.
+func TestSharedOperations(t *testing.T) {
+	for _, policy := range policies {
+		c, err := New(100, 0, policy, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fill := func() {
+			c.Purge()
+			c.SetItem(Item{Key: "user:1", Value: 1, Tags: []string{"users", "admins"}})
+			c.SetItem(Item{Key: "user:2", Value: 2, Tags: []string{"users"}})
+			c.SetItem(Item{Key: "user:3", Value: 3, TimeToLive: time.Nanosecond})
+			c.SetItem(Item{Key: "order:1", Value: 4, TimeToLive: time.Hour})
+			time.Sleep(time.Millisecond)
+		}
+
+		fill()
+		keys, nextCursor, _ := c.Scan("user:", "", 1)
+		if len(keys) != 1 || keys[0].Key != "user:1" || nextCursor != "user:1" {
+			t.Errorf("%v: first page %+v with cursor %q; expected user:1", policy, keys, nextCursor)
+		}
+		keys, nextCursor, _ = c.Scan("user:", nextCursor, 5)
+		if len(keys) != 1 || keys[0].Key != "user:2" || nextCursor != "" {
+			t.Errorf("%v: last page %+v with cursor %q; expected only user:2, the expired user:3 being skipped", policy, keys, nextCursor)
+		}
+		if keys, _, _ := c.Scan("order:", "", 0); len(keys) != 1 || keys[0].TimeToLive <= 0 {
+			t.Errorf("%v: scanned %+v; expected order:1 with its time-to-live", policy, keys)
+		}
+
+		if deleted, _ := c.DeleteTagged("users"); !reflect.DeepEqual(sortedKeys(deleted), []string{"user:1", "user:2"}) {
+			t.Errorf("%v: deleted %v by tag; expected user:1 and user:2", policy, deleted)
+		}
+		if deleted, _ := c.DeleteTagged("admins"); len(deleted) != 0 {
+			t.Errorf("%v: deleted %v by a tag of deleted items; expected nothing", policy, deleted)
+		}
+
+		fill()
+		if deleted, _ := c.DeleteMatching(regexp.MustCompile(`^user:[12]$`)); !reflect.DeepEqual(sortedKeys(deleted), []string{"user:1", "user:2"}) {
+			t.Errorf("%v: deleted %v by pattern; expected user:1 and user:2", policy, deleted)
+		}
+
+		fill()
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			c.DeleteExpired(time.Millisecond, stop)
+			close(done)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		close(stop)
+		<-done
+		if info, _ := c.Info(); info["size"] != uint64(3) {
+			t.Errorf("%v: %v items left after the sweep; expected 3", policy, info["size"])
+		}
+		if _, err := c.Get("order:1"); err != nil {
+			t.Errorf("%v: unexpired item swept: %v", policy, err)
+		}
+	}
+}
+
+const (
+	benchmarkCapacity = 10000
+	benchmarkKeys     = 100000
+	benchmarkSkew     = 1.1
+)
+
+// zipfKeys returns a generator of keys following a Zipfian distribution, as
+// most cache workloads do.
+func zipfKeys(seed int64) func() string {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(seed)), benchmarkSkew, 1, benchmarkKeys-1)
+	return func() string {
+		return strconv.FormatUint(zipf.Uint64(), 10)
+	}
+}
+
+// benchmarkGet measures reads from parallel goroutines using the cache-aside
+// pattern: every key is read first and only written after a miss, so writes
+// are as rare as misses. The hit ratio is reported along with the time per
+// read, to compare the policies on both.
+func benchmarkGet(b *testing.B, evictionPolicy EvictionPolicy) {
+	c, err := New(benchmarkCapacity, 0, evictionPolicy, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	nextKey := zipfKeys(0)
+	for i := 0; i < 10*benchmarkCapacity; i++ {
+		key := nextKey()
+		if err := c.Set(key, key); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var seed, hits, reads atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		nextKey := zipfKeys(seed.Add(1))
+		var localHits, localReads int64
+		for pb.Next() {
+			key := nextKey()
+			localReads++
+			if _, err := c.Get(key); err == nil {
+				localHits++
+			} else {
+				c.Set(key, key)
+			}
+		}
+		hits.Add(localHits)
+		reads.Add(localReads)
+	})
+	b.StopTimer()
+
+	if reads.Load() != 0 {
+		b.ReportMetric(float64(hits.Load())/float64(reads.Load()), "hits/read")
+	}
+}
+
+func BenchmarkGetLRU(b *testing.B)     { benchmarkGet(b, LRU) }
+func BenchmarkGetLFU(b *testing.B)     { benchmarkGet(b, LFU) }
+func BenchmarkGetARC(b *testing.B)     { benchmarkGet(b, ARC) }
+func BenchmarkGetTinyLFU(b *testing.B) { benchmarkGet(b, TinyLFU) }
+func BenchmarkGetSIEVE(b *testing.B)   { benchmarkGet(b, SIEVE) }
+func BenchmarkGetS3FIFO(b *testing.B)  { benchmarkGet(b, S3FIFO) }
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CompileGlob turns a glob pattern into a regular expression matching whole
+// keys. Unlike path.Match, `*` also matches separators such as `/` and `:`,
+// `?` matches a single character, `[...]` matches a character class and `\`
+// escapes the next character.
+func CompileGlob(pattern string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+	builder.WriteString("(?s)^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			builder.WriteString(".*")
+		case '?':
+			builder.WriteString(".")
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("trailing backslash in %q", pattern)
+			}
+			i++
+			builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			end := i + 1
+			if end < len(runes) && (runes[end] == '!' || runes[end] == '^') {
+				end++
+			}
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated character class in %q", pattern)
+			}
+
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
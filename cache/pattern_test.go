@@ -0,0 +1,49 @@
+package cache
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		matches    []string
+		mismatches []string
+	}{
+		{"user:*", []string{"user:", "user:1", "user:1/orders", "user:a\nb"}, []string{"user", "users:1", "a:user:1"}},
+		{"*:1", []string{"user:1", "a/b:1", ":1"}, []string{"user:12", "user:1:"}},
+		{"user:?", []string{"user:1", "user:é"}, []string{"user:", "user:12"}},
+		{"user:[0-9]", []string{"user:0", "user:9"}, []string{"user:a", "user:10"}},
+		{"user:[!0-9]", []string{"user:a", "user:-"}, []string{"user:1"}},
+		{"user:[^0-9]", []string{"user:a"}, []string{"user:1"}},
+		{"user:[]a]", []string{"user:]", "user:a"}, []string{"user:b"}},
+		{`a[\]`, []string{`a\`}, []string{"a]"}},
+		{`user:\*`, []string{"user:*"}, []string{"user:1"}},
+		{`what\?`, []string{"what?"}, []string{"whats"}},
+		{`\[a]`, []string{"[a]"}, []string{"a"}},
+		{"a.b+c(d)|e$", []string{"a.b+c(d)|e$"}, []string{"axb+c(d)|e$", "abbc"}},
+	}
+	for _, test := range tests {
+		pattern, err := CompileGlob(test.pattern)
+		if err != nil {
+			t.Errorf("%q: %v", test.pattern, err)
+			continue
+		}
+		for _, key := range test.matches {
+			if !pattern.MatchString(key) {
+				t.Errorf("%q does not match %q", test.pattern, key)
+			}
+		}
+		for _, key := range test.mismatches {
+			if pattern.MatchString(key) {
+				t.Errorf("%q matches %q", test.pattern, key)
+			}
+		}
+	}
+}
+
+func TestCompileGlobRejectsInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{`user:\`, "user:[0-9", "user:[!", "user:[]", "user:[z-a]"} {
+		if _, err := CompileGlob(pattern); err == nil {
+			t.Errorf("%q compiled", pattern)
+		}
+	}
+}
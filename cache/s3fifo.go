@@ -0,0 +1,349 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+const s3FIFOMaxFrequency = 3
+
+// S3FIFOCache implements S3-FIFO. New items enter a small FIFO queue holding
+// 10% of the capacity, and the others live in a main FIFO queue. Hits only
+// raise the frequency of an item, up to 3, which lets reads run under the
+// read lock. Items leaving the small queue move to the main queue if they
+// were hit more than once, and are evicted otherwise, with their key kept
+// in a ghost queue. Keys found in the ghost queue go straight to the main
+// queue. Items leaving the main queue are reinserted while their frequency,
+// decremented each time, is not zero.
+type S3FIFOCache struct {
+	cacheInfo
+	small      *list.List
+	main       *list.List
+	ghostQueue *list.List
+	items      map[string]*list.Element
+	ghosts     map[string]*list.Element
+}
+
+func (c *S3FIFOCache) reset() {
+	c.small = &list.List{}
+	c.main = &list.List{}
+	c.ghostQueue = &list.List{}
+	c.items = make(map[string]*list.Element)
+	c.ghosts = make(map[string]*list.Element)
+	c.clear()
+}
+
+// queueCapacity is the number of items the queues are sized against. A
+// cache limited only by memory uses the number of items it currently holds.
+func (c *S3FIFOCache) queueCapacity() uint64 {
+	if c.capacity != 0 {
+		return c.capacity
+	}
+	return c.size
+}
+
+func (c *S3FIFOCache) queue(item *fifoItem) *list.List {
+	if item.small {
+		return c.small
+	}
+	return c.main
+}
+
+func (c *S3FIFOCache) Set(key string, value interface{}, timeToLive ...time.Duration) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.set(key, value, nil, timeToLive...)
+}
+
+func (c *S3FIFOCache) SetItem(item Item) (err error) {
+	return c.setItem(c, item)
+}
+
+func (c *S3FIFOCache) SetMany(items []Item) (errs []error) {
+	return c.setMany(c, items)
+}
+
+func (c *S3FIFOCache) set(key string, value interface{}, tags []string, timeToLive ...time.Duration) (err error) {
+	itemSize := estimateSize(key, value) + estimateTagsSize(tags)
+	if !c.fits(itemSize) {
+		return ErrItemTooLarge
+	}
+
+	var item *fifoItem
+	if listElement, ok := c.items[key]; ok {
+		item = listElement.Value.(*fifoItem)
+		item.touch(s3FIFOMaxFrequency)
+		item.value = value
+		c.memoryUsage = c.memoryUsage - item.size + itemSize
+		item.size = itemSize
+
+		for c.exceedsMemory(0) {
+			c.evict(listElement)
+		}
+	} else {
+		for c.size > 0 && c.isFull(itemSize) {
+			c.evict(nil)
+		}
+
+		item = newFIFOItem(&cacheItem{key: key, value: value, size: itemSize})
+		if ghostElement, ok := c.ghosts[key]; ok {
+			c.removeGhost(ghostElement)
+			c.items[key] = c.main.PushFront(item)
+		} else {
+			item.small = true
+			c.items[key] = c.small.PushFront(item)
+		}
+		c.addItem(item.cacheItem)
+	}
+
+	c.updateItem(item.cacheItem, tags, timeToLive...)
+
+	return nil
+}
+
+// evict removes one item, from the small queue once it holds its share of
+// the capacity, and from the main queue otherwise. The spared element, which
+// is being updated, is never evicted.
+func (c *S3FIFOCache) evict(spared *list.Element) {
+	for attempts := 0; attempts <= 2*s3FIFOMaxFrequency*int(c.size)+2; attempts++ {
+		fromSmall := c.main.Len() == 0 || uint64(c.small.Len())*10 >= c.queueCapacity()
+		if c.small.Len() == 0 || (c.small.Len() == 1 && c.small.Back() == spared) {
+			fromSmall = false
+		}
+
+		if fromSmall {
+			listElement := c.small.Back()
+			if listElement == spared {
+				listElement = listElement.Prev()
+			}
+			item := listElement.Value.(*fifoItem)
+			if item.frequency.Load() > 1 {
+				c.small.Remove(listElement)
+				item.small = false
+				item.frequency.Store(0)
+				c.items[item.key] = c.main.PushFront(item)
+				continue
+			}
+
+			c.removeCacheItem(listElement, item.key)
+			c.addGhost(item.key)
+			return
+		}
+
+		listElement := c.main.Back()
+		if listElement == nil {
+			return
+		}
+		item := listElement.Value.(*fifoItem)
+		if listElement == spared || item.frequency.Load() > 0 {
+			if listElement != spared {
+				item.frequency.Add(-1)
+			}
+			c.main.MoveToFront(listElement)
+			continue
+		}
+
+		c.removeCacheItem(listElement, item.key)
+		return
+	}
+}
+
+// addGhost remembers an evicted key, keeping as many keys as the main queue
+// can hold items.
+func (c *S3FIFOCache) addGhost(key string) {
+	c.ghosts[key] = c.ghostQueue.PushFront(key)
+	for c.ghostQueue.Len() > 0 && uint64(c.ghostQueue.Len())*10 > c.queueCapacity()*9 {
+		c.removeGhost(c.ghostQueue.Back())
+	}
+}
+
+func (c *S3FIFOCache) removeGhost(ghostElement *list.Element) {
+	c.ghostQueue.Remove(ghostElement)
+	delete(c.ghosts, ghostElement.Value.(string))
+}
+
+func (c *S3FIFOCache) Get(key string) (value interface{}, err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.value, nil
+}
+
+func (c *S3FIFOCache) GetItem(key string) (item Item, err error) {
+	return c.getItem(c, key)
+}
+
+func (c *S3FIFOCache) GetMany(keys []string) (items []Item, errs []error) {
+	return c.getMany(c, keys)
+}
+
+func (c *S3FIFOCache) get(key string) (item *cacheItem, err error) {
+	if listElement, ok := c.items[key]; ok {
+		item := listElement.Value.(*fifoItem)
+		if item.isExpired(time.Now()) {
+			return nil, fmt.Errorf("item does not exist")
+		}
+
+		item.touch(s3FIFOMaxFrequency)
+		return item.cacheItem, nil
+	} else {
+		return nil, fmt.Errorf("item does not exist")
+	}
+}
+
+func (c *S3FIFOCache) Delete(key string) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.delete(c, key)
+}
+
+func (c *S3FIFOCache) DeleteMany(keys []string) (errs []error) {
+	return c.deleteMany(c, keys)
+}
+
+func (c *S3FIFOCache) remove(key string) {
+	if listElement, ok := c.items[key]; ok {
+		c.removeCacheItem(listElement, key)
+	}
+}
+
+func (c *S3FIFOCache) DeleteMatching(pattern *regexp.Regexp) (keys []string, err error) {
+	return c.deleteMatching(c, pattern), nil
+}
+
+func (c *S3FIFOCache) DeleteTagged(tag string) (keys []string, err error) {
+	return c.deleteTagged(c, tag), nil
+}
+
+func (c *S3FIFOCache) Purge() (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.reset()
+	return nil
+}
+
+func (c *S3FIFOCache) removeCacheItem(listElement *list.Element, key string) {
+	item := listElement.Value.(*fifoItem)
+	c.queue(item).Remove(listElement)
+	c.removeItem(item.cacheItem)
+	delete(c.items, key)
+}
+
+func (c *S3FIFOCache) DeleteExpired(timeInterval time.Duration, stop <-chan struct{}) {
+	c.deleteExpired(c, timeInterval, stop)
+}
+
+func (c *S3FIFOCache) Scan(prefix string, cursor string, limit int) (keys []KeyInfo, nextCursor string, err error) {
+	keys, nextCursor = c.scan(c, prefix, cursor, limit)
+	return keys, nextCursor, nil
+}
+
+func (c *S3FIFOCache) forEachItem(visit func(item *cacheItem)) {
+	for _, listElement := range c.items {
+		visit(listElement.Value.(*fifoItem).cacheItem)
+	}
+}
+
+func (c *S3FIFOCache) keyInfo(key string, now time.Time) (info KeyInfo, ok bool) {
+	listElement, ok := c.items[key]
+	if !ok || listElement.Value.(*fifoItem).isExpired(now) {
+		return KeyInfo{}, false
+	}
+	return listElement.Value.(*fifoItem).keyInfo(now), true
+}
+
+func (c *S3FIFOCache) Info() (info map[string]interface{}, err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	info = c.info()
+	info["smallSize"] = c.small.Len()
+	info["mainSize"] = c.main.Len()
+	info["ghosts"] = c.ghostQueue.Len()
+	return info, nil
+}
+
+func (c *S3FIFOCache) Resize(capacity uint64, maxMemory uint64) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.capacity = capacity
+	c.maxMemory = maxMemory
+	for c.size > 0 && c.isOverLimit() {
+		c.evict(nil)
+	}
+	for c.ghostQueue.Len() > 0 && uint64(c.ghostQueue.Len())*10 > c.queueCapacity()*9 {
+		c.removeGhost(c.ghostQueue.Back())
+	}
+	return nil
+}
+
+// Save stores the main queue and then the small queue, each from the oldest
+// to the newest item, along with their frequencies. Ghost keys are not
+// saved.
+func (c *S3FIFOCache) Save(w io.Writer) (err error) {
+	c.RLock()
+	items := make([]snapshotItem, 0, c.size)
+	for _, queue := range []*list.List{c.main, c.small} {
+		for listElement := queue.Back(); listElement != nil; listElement = listElement.Prev() {
+			item := listElement.Value.(*fifoItem)
+			items = append(items, snapshotItem{
+				Key:            item.key,
+				Value:          item.value,
+				ExpirationTime: item.expirationTime,
+				Frequency:      uint64(item.frequency.Load()),
+				Tags:           item.tags,
+			})
+		}
+	}
+	c.RUnlock()
+
+	return writeSnapshot(w, S3FIFO, items)
+}
+
+// Load restores every item to the main queue, in the order of the snapshot,
+// since they have already proven useful once.
+func (c *S3FIFOCache) Load(r io.Reader) (err error) {
+	s, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.reset()
+	c.load(c, s.Items)
+
+	// Evicting while loading only fills the ghost queue with keys that were
+	// not restored.
+	c.ghostQueue = &list.List{}
+	c.ghosts = make(map[string]*list.Element)
+	return nil
+}
+
+func (c *S3FIFOCache) restore(cacheItem *cacheItem, frequency uint64) {
+	for c.size > 0 && c.isFull(cacheItem.size) {
+		c.evict(nil)
+	}
+
+	item := newFIFOItem(cacheItem)
+	if frequency > s3FIFOMaxFrequency {
+		item.frequency.Store(s3FIFOMaxFrequency)
+	} else {
+		item.frequency.Store(int32(frequency))
+	}
+	c.items[item.key] = c.main.PushFront(item)
+	c.tagItem(item.cacheItem)
+	c.addItem(item.cacheItem)
+}
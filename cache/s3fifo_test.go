@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// s3FIFOState lists the keys of the small, main and ghost queues from newest
+// to oldest.
+type s3FIFOState struct {
+	small, main, ghosts []string
+}
+
+func (c *S3FIFOCache) state() s3FIFOState {
+	state := s3FIFOState{small: []string{}, main: []string{}, ghosts: []string{}}
+	for listElement := c.small.Front(); listElement != nil; listElement = listElement.Next() {
+		state.small = append(state.small, listElement.Value.(*fifoItem).key)
+	}
+	for listElement := c.main.Front(); listElement != nil; listElement = listElement.Next() {
+		state.main = append(state.main, listElement.Value.(*fifoItem).key)
+	}
+	for listElement := c.ghostQueue.Front(); listElement != nil; listElement = listElement.Next() {
+		state.ghosts = append(state.ghosts, listElement.Value.(string))
+	}
+	return state
+}
+
+// fillOperations sets the keys k0 to k<n-1>, reading each of them the given
+// number of times.
+func fillOperations(n int, reads int) []string {
+	var operations []string
+	for i := 0; i < n; i++ {
+		operations = append(operations, fmt.Sprintf("set k%v", i))
+		for j := 0; j < reads; j++ {
+			operations = append(operations, fmt.Sprintf("get k%v", i))
+		}
+	}
+	return operations
+}
+
+func TestS3FIFO(t *testing.T) {
+	tests := []struct {
+		name       string
+		capacity   uint64
+		operations []string
+		expected   s3FIFOState
+	}{
+		{
+			"new items enter the small queue",
+			10,
+			[]string{"set a", "set b"},
+			s3FIFOState{small: []string{"b", "a"}, main: []string{}, ghosts: []string{}},
+		},
+		{
+			"items hit once leave the small queue for the ghost queue",
+			10,
+			append([]string{"set a", "get a"}, append(fillOperations(9, 0), "set b")...),
+			s3FIFOState{
+				small:  []string{"b", "k8", "k7", "k6", "k5", "k4", "k3", "k2", "k1", "k0"},
+				main:   []string{},
+				ghosts: []string{"a"},
+			},
+		},
+		{
+			"items hit more than once move from the small queue to the main queue",
+			10,
+			append([]string{"set a", "get a", "get a"}, append(fillOperations(9, 0), "set b")...),
+			s3FIFOState{
+				small:  []string{"b", "k8", "k7", "k6", "k5", "k4", "k3", "k2", "k1"},
+				main:   []string{"a"},
+				ghosts: []string{"k0"},
+			},
+		},
+		{
+			"a ghost hit enters the main queue",
+			10,
+			append([]string{"set a"}, append(fillOperations(9, 0), "set b", "set a")...),
+			s3FIFOState{
+				small:  []string{"b", "k8", "k7", "k6", "k5", "k4", "k3", "k2", "k1"},
+				main:   []string{"a"},
+				ghosts: []string{"k0"},
+			},
+		},
+		{
+			"the main queue evicts once the small queue is below 10% of the capacity",
+			20,
+			append(fillOperations(20, 2), "set a"),
+			s3FIFOState{
+				small: []string{"a", "k19"},
+				main: []string{
+					"k18", "k17", "k16", "k15", "k14", "k13", "k12", "k11", "k10",
+					"k9", "k8", "k7", "k6", "k5", "k4", "k3", "k2", "k1",
+				},
+				ghosts: []string{},
+			},
+		},
+		{
+			"the main queue reinserts items that were hit",
+			20,
+			append(fillOperations(20, 2), "set a", "get k1", "set b"),
+			s3FIFOState{
+				small: []string{"b", "a"},
+				main: []string{
+					"k1", "k19", "k18", "k17", "k16", "k15", "k14", "k13", "k12", "k11",
+					"k10", "k9", "k8", "k7", "k6", "k5", "k4", "k3",
+				},
+				ghosts: []string{},
+			},
+		},
+	}
+	for _, test := range tests {
+		c, _ := New(test.capacity, 0, S3FIFO, 0)
+		s3FIFO := c.(*S3FIFOCache)
+		runOperations(s3FIFO, test.operations)
+		if state := s3FIFO.state(); !reflect.DeepEqual(state, test.expected) {
+			t.Errorf("%v: %+v; expected %+v", test.name, state, test.expected)
+		}
+	}
+}
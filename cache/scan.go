@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"strings"
+	"time"
+)
+
+// KeyInfo describes an item listed by Scan. Frequency is only set by the LFU
+// cache, and estimated by the TinyLFU cache. TimeToLive is zero for items
+// that never expire.
+//
+// LastAccess stands in for the recency rank of the item: sorting the listed
+// items by it gives their order in the recency list of the LRU cache, and
+// the order in which the other policies last saw them. A rank would require
+// walking the recency list up to each listed item while holding the lock,
+// which is the cost Scan avoids, and it would be stale as soon as the next
+// item is accessed anyway.
+type KeyInfo struct {
+	Key        string
+	TimeToLive time.Duration
+	Frequency  uint64
+	LastAccess time.Time
+}
+
+func newKeyInfo(item *cacheItem, now time.Time) KeyInfo {
+	info := KeyInfo{Key: item.key, LastAccess: item.lastAccess}
+	if !item.expirationTime.IsZero() {
+		info.TimeToLive = item.expirationTime.Sub(now)
+	}
+	return info
+}
+
+// scanChunkSize bounds the number of keys visited while holding the read
+// lock, so that a long scan lets writers in between chunks.
+const scanChunkSize = 256
+
+// scan walks the sorted keys from the cursor in chunks, taking the read lock
+// for each chunk only and seeking back after the last visited key for the
+// next one, so that keys set or deleted in between are handled. Keys are
+// listed in lexicographical order, so the cursor is simply the last key of
+// the previous page, and the next cursor is empty when there are no more
+// keys.
+func (c *cacheInfo) scan(index itemIndex, prefix string, cursor string, limit int) ([]KeyInfo, string) {
+	now := time.Now()
+	keys := make([]KeyInfo, 0)
+	after := cursor
+	for {
+		start := prefix
+		if after > prefix {
+			start = after
+		}
+
+		c.RLock()
+		node := c.keys.seek(start)
+		for visited := 0; node != nil && visited < scanChunkSize; node = node.next[0] {
+			if node.key <= after {
+				continue
+			}
+			if !strings.HasPrefix(node.key, prefix) {
+				node = nil
+				break
+			}
+			visited++
+			after = node.key
+
+			if keyInfo, ok := index.keyInfo(node.key, now); ok {
+				if limit > 0 && len(keys) == limit {
+					c.RUnlock()
+					return keys, keys[limit-1].Key
+				}
+				keys = append(keys, keyInfo)
+			}
+		}
+		c.RUnlock()
+
+		if node == nil {
+			return keys, ""
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestKeyIndex(t *testing.T) {
+	var index keyIndex
+	expected := make(map[string]struct{})
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		key := strconv.Itoa(random.Intn(500))
+		if random.Intn(3) == 0 {
+			index.remove(key)
+			delete(expected, key)
+		} else {
+			index.insert(key)
+			expected[key] = struct{}{}
+		}
+	}
+
+	keys := make([]string, 0)
+	for node := index.seek(""); node != nil; node = node.next[0] {
+		keys = append(keys, node.key)
+	}
+	expectedKeys := make([]string, 0, len(expected))
+	for key := range expected {
+		expectedKeys = append(expectedKeys, key)
+	}
+	sort.Strings(expectedKeys)
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Fatalf("indexed %v; expected %v", keys, expectedKeys)
+	}
+
+	if node := index.seek("25"); node == nil || node.key < "25" {
+		t.Errorf("seeked %+v; expected the first key from 25", node)
+	}
+	if node := index.seek("~"); node != nil {
+		t.Errorf("seeked %v past every key", node.key)
+	}
+}
+
+// TestScanAcrossChunks lists more keys than a chunk holds, page by page,
+// while keys are set and deleted between the pages.
+func TestScanAcrossChunks(t *testing.T) {
+	const count = 3 * scanChunkSize
+	for _, policy := range policies {
+		c, err := New(0, 0, policy, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			c.Set(fmt.Sprintf("key:%04d", i), i)
+			c.Set(fmt.Sprintf("other:%04d", i), i)
+		}
+
+		listed := make([]string, 0, count)
+		cursor := ""
+		pages := 0
+		for ; ; pages++ {
+			keys, nextCursor, _ := c.Scan("key:", cursor, 100)
+			for _, key := range keys {
+				listed = append(listed, key.Key)
+			}
+			if nextCursor == "" {
+				break
+			}
+			cursor = nextCursor
+
+			// The deleted key was already listed, and the set one comes
+			// after the cursor.
+			c.Delete(fmt.Sprintf("key:%04d", pages))
+			c.Set(fmt.Sprintf("key:%04d", count+pages), pages)
+		}
+
+		if !sort.StringsAreSorted(listed) {
+			t.Errorf("%v: listed keys out of order", policy)
+		}
+		if len(listed) != count+pages || listed[0] != "key:0000" {
+			t.Errorf("%v: listed %v keys from %v; expected %v from key:0000", policy, len(listed), listed[0], count+pages)
+		}
+	}
+}
+
+// TestScanDoesNotPromote lists the keys of an LRU cache in the reverse of
+// their insertion order, which promoting them would turn around.
+func TestScanDoesNotPromote(t *testing.T) {
+	c, err := New(3, 0, LRU, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"c", "b", "a"} {
+		c.Set(key, key)
+	}
+
+	keys, _, _ := c.Scan("", "", 0)
+	if len(keys) != 3 || !keys[0].LastAccess.After(keys[2].LastAccess) {
+		t.Fatalf("scanned %+v; expected a accessed after c", keys)
+	}
+	if again, _, _ := c.Scan("", "", 0); !reflect.DeepEqual(again, keys) {
+		t.Errorf("scanned %+v after a scan; expected %+v", again, keys)
+	}
+
+	c.Set("d", "d")
+	if _, err := c.Get("c"); err == nil {
+		t.Error("c was kept; expected the least recently used item to be evicted")
+	}
+	for _, key := range []string{"a", "b", "d"} {
+		if _, err := c.Get(key); err != nil {
+			t.Errorf("%v was evicted instead of c", key)
+		}
+	}
+}
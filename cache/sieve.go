@@ -0,0 +1,266 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// SIEVECache implements SIEVE. Items are kept in insertion order, and a hit
+// only marks the item as visited, which lets reads run under the read lock.
+// To evict, a hand moves from the oldest item towards the newest, clearing
+// the mark of visited items and evicting the first item that is not marked.
+type SIEVECache struct {
+	cacheInfo
+	queue *list.List
+	items map[string]*list.Element
+	hand  *list.Element
+}
+
+func (c *SIEVECache) Set(key string, value interface{}, timeToLive ...time.Duration) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.set(key, value, nil, timeToLive...)
+}
+
+func (c *SIEVECache) SetItem(item Item) (err error) {
+	return c.setItem(c, item)
+}
+
+func (c *SIEVECache) SetMany(items []Item) (errs []error) {
+	return c.setMany(c, items)
+}
+
+func (c *SIEVECache) set(key string, value interface{}, tags []string, timeToLive ...time.Duration) (err error) {
+	itemSize := estimateSize(key, value) + estimateTagsSize(tags)
+	if !c.fits(itemSize) {
+		return ErrItemTooLarge
+	}
+
+	var item *fifoItem
+	if listElement, ok := c.items[key]; ok {
+		item = listElement.Value.(*fifoItem)
+		item.touch(1)
+		item.value = value
+		c.memoryUsage = c.memoryUsage - item.size + itemSize
+		item.size = itemSize
+
+		for c.exceedsMemory(0) {
+			c.evict(listElement)
+		}
+	} else {
+		for c.size > 0 && c.isFull(itemSize) {
+			c.evict(nil)
+		}
+
+		item = newFIFOItem(&cacheItem{key: key, value: value, size: itemSize})
+		c.items[key] = c.queue.PushFront(item)
+		c.addItem(item.cacheItem)
+	}
+
+	c.updateItem(item.cacheItem, tags, timeToLive...)
+
+	return nil
+}
+
+// evict moves the hand towards the newest item until it finds an item that
+// has not been visited since the hand last passed it, and evicts it. The
+// spared element, which is being updated, is never evicted.
+func (c *SIEVECache) evict(spared *list.Element) {
+	for attempts := 0; attempts <= 2*c.queue.Len(); attempts++ {
+		listElement := c.hand
+		if listElement == nil {
+			listElement = c.queue.Back()
+		}
+		if listElement == nil {
+			return
+		}
+		c.hand = listElement.Prev()
+
+		item := listElement.Value.(*fifoItem)
+		if listElement == spared {
+			continue
+		}
+		if item.frequency.Load() != 0 {
+			item.frequency.Store(0)
+			continue
+		}
+
+		c.removeCacheItem(listElement, item.key)
+		return
+	}
+}
+
+func (c *SIEVECache) Get(key string) (value interface{}, err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.value, nil
+}
+
+func (c *SIEVECache) GetItem(key string) (item Item, err error) {
+	return c.getItem(c, key)
+}
+
+func (c *SIEVECache) GetMany(keys []string) (items []Item, errs []error) {
+	return c.getMany(c, keys)
+}
+
+func (c *SIEVECache) get(key string) (item *cacheItem, err error) {
+	if listElement, ok := c.items[key]; ok {
+		item := listElement.Value.(*fifoItem)
+		if item.isExpired(time.Now()) {
+			return nil, fmt.Errorf("item does not exist")
+		}
+
+		item.touch(1)
+		return item.cacheItem, nil
+	} else {
+		return nil, fmt.Errorf("item does not exist")
+	}
+}
+
+func (c *SIEVECache) Delete(key string) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.delete(c, key)
+}
+
+func (c *SIEVECache) DeleteMany(keys []string) (errs []error) {
+	return c.deleteMany(c, keys)
+}
+
+func (c *SIEVECache) remove(key string) {
+	if listElement, ok := c.items[key]; ok {
+		c.removeCacheItem(listElement, key)
+	}
+}
+
+func (c *SIEVECache) DeleteMatching(pattern *regexp.Regexp) (keys []string, err error) {
+	return c.deleteMatching(c, pattern), nil
+}
+
+func (c *SIEVECache) DeleteTagged(tag string) (keys []string, err error) {
+	return c.deleteTagged(c, tag), nil
+}
+
+func (c *SIEVECache) Purge() (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.queue = &list.List{}
+	c.items = make(map[string]*list.Element)
+	c.hand = nil
+	c.clear()
+	return nil
+}
+
+func (c *SIEVECache) removeCacheItem(listElement *list.Element, key string) {
+	if c.hand == listElement {
+		c.hand = listElement.Prev()
+	}
+	c.queue.Remove(listElement)
+	c.removeItem(listElement.Value.(*fifoItem).cacheItem)
+	delete(c.items, key)
+}
+
+func (c *SIEVECache) DeleteExpired(timeInterval time.Duration, stop <-chan struct{}) {
+	c.deleteExpired(c, timeInterval, stop)
+}
+
+func (c *SIEVECache) Scan(prefix string, cursor string, limit int) (keys []KeyInfo, nextCursor string, err error) {
+	keys, nextCursor = c.scan(c, prefix, cursor, limit)
+	return keys, nextCursor, nil
+}
+
+func (c *SIEVECache) forEachItem(visit func(item *cacheItem)) {
+	for _, listElement := range c.items {
+		visit(listElement.Value.(*fifoItem).cacheItem)
+	}
+}
+
+func (c *SIEVECache) keyInfo(key string, now time.Time) (info KeyInfo, ok bool) {
+	listElement, ok := c.items[key]
+	if !ok || listElement.Value.(*fifoItem).isExpired(now) {
+		return KeyInfo{}, false
+	}
+	return listElement.Value.(*fifoItem).keyInfo(now), true
+}
+
+func (c *SIEVECache) Info() (info map[string]interface{}, err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.info(), nil
+}
+
+func (c *SIEVECache) Resize(capacity uint64, maxMemory uint64) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.capacity = capacity
+	c.maxMemory = maxMemory
+	for c.size > 0 && c.isOverLimit() {
+		c.evict(nil)
+	}
+	return nil
+}
+
+// Save stores the items from the oldest to the newest. Visited items are
+// marked with a frequency of 1.
+func (c *SIEVECache) Save(w io.Writer) (err error) {
+	c.RLock()
+	items := make([]snapshotItem, 0, c.size)
+	for listElement := c.queue.Back(); listElement != nil; listElement = listElement.Prev() {
+		item := listElement.Value.(*fifoItem)
+		items = append(items, snapshotItem{
+			Key:            item.key,
+			Value:          item.value,
+			ExpirationTime: item.expirationTime,
+			Frequency:      uint64(item.frequency.Load()),
+			Tags:           item.tags,
+		})
+	}
+	c.RUnlock()
+
+	return writeSnapshot(w, SIEVE, items)
+}
+
+func (c *SIEVECache) Load(r io.Reader) (err error) {
+	s, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.queue = &list.List{}
+	c.items = make(map[string]*list.Element)
+	c.hand = nil
+	c.clear()
+	c.load(c, s.Items)
+	return nil
+}
+
+func (c *SIEVECache) restore(cacheItem *cacheItem, frequency uint64) {
+	for c.size > 0 && c.isFull(cacheItem.size) {
+		c.evict(nil)
+	}
+
+	item := newFIFOItem(cacheItem)
+	if frequency != 0 {
+		item.frequency.Store(1)
+	}
+	c.items[item.key] = c.queue.PushFront(item)
+	c.tagItem(item.cacheItem)
+	c.addItem(item.cacheItem)
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sieveState lists the keys of the queue from newest to oldest, the keys of
+// the visited items and the key the hand points to.
+type sieveState struct {
+	queue, visited []string
+	hand           string
+}
+
+func (c *SIEVECache) state() sieveState {
+	state := sieveState{queue: []string{}, visited: []string{}}
+	for listElement := c.queue.Front(); listElement != nil; listElement = listElement.Next() {
+		item := listElement.Value.(*fifoItem)
+		state.queue = append(state.queue, item.key)
+		if item.frequency.Load() != 0 {
+			state.visited = append(state.visited, item.key)
+		}
+	}
+	if c.hand != nil {
+		state.hand = c.hand.Value.(*fifoItem).key
+	}
+	return state
+}
+
+func TestSIEVE(t *testing.T) {
+	tests := []struct {
+		name       string
+		capacity   uint64
+		operations []string
+		expected   sieveState
+	}{
+		{
+			"reads and updates mark items as visited without moving them",
+			3,
+			[]string{"set a", "set b", "set c", "get a", "set b"},
+			sieveState{queue: []string{"c", "b", "a"}, visited: []string{"b", "a"}},
+		},
+		{
+			"the hand starts at the oldest item and evicts the first item not visited",
+			3,
+			[]string{"set a", "set b", "set c", "get a", "set d"},
+			sieveState{queue: []string{"d", "c", "a"}, visited: []string{}, hand: "c"},
+		},
+		{
+			"the hand resumes where it stopped",
+			3,
+			[]string{"set a", "set b", "set c", "get a", "set d", "get a", "set e"},
+			sieveState{queue: []string{"e", "d", "a"}, visited: []string{"a"}, hand: "d"},
+		},
+		{
+			"the hand wraps around to the oldest item once every item is cleared",
+			3,
+			[]string{"set a", "set b", "set c", "get a", "get b", "get c", "set d"},
+			sieveState{queue: []string{"d", "c", "b"}, visited: []string{}, hand: "b"},
+		},
+	}
+	for _, test := range tests {
+		c, _ := New(test.capacity, 0, SIEVE, 0)
+		sieve := c.(*SIEVECache)
+		runOperations(sieve, test.operations)
+		if state := sieve.state(); !reflect.DeepEqual(state, test.expected) {
+			t.Errorf("%v: %+v; expected %+v", test.name, state, test.expected)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"encoding/json"
+	"reflect"
+	"unsafe"
+)
+
+const (
+	itemOverhead      = uint64(unsafe.Sizeof(cacheItem{})) + 64
+	interfaceOverhead = uint64(unsafe.Sizeof((interface{})(nil)))
+	stringOverhead    = uint64(unsafe.Sizeof(""))
+	sliceOverhead     = uint64(unsafe.Sizeof([]interface{}{}))
+	mapEntryOverhead  = 16
+)
+
+// estimateSize approximates the number of bytes retained by a cache entry.
+// Values decoded from JSON are walked recursively; anything else falls back
+// to the shallow size of its type.
+func estimateSize(key string, value interface{}) uint64 {
+	return itemOverhead + uint64(len(key)) + estimateValueSize(value)
+}
+
+func estimateTagsSize(tags []string) uint64 {
+	var size uint64
+	for _, tag := range tags {
+		size += stringOverhead + uint64(len(tag))
+	}
+	return size
+}
+
+func estimateValueSize(value interface{}) uint64 {
+	switch v := value.(type) {
+	case nil:
+		return interfaceOverhead
+	case bool, float64, int, int64, uint64:
+		return interfaceOverhead + 8
+	case string:
+		return interfaceOverhead + stringOverhead + uint64(len(v))
+	case json.Number:
+		return interfaceOverhead + stringOverhead + uint64(len(v))
+	case []interface{}:
+		size := interfaceOverhead + sliceOverhead
+		for _, element := range v {
+			size += estimateValueSize(element)
+		}
+		return size
+	case map[string]interface{}:
+		size := interfaceOverhead + uint64(unsafe.Sizeof(v))
+		for key, element := range v {
+			size += mapEntryOverhead + stringOverhead + uint64(len(key)) + estimateValueSize(element)
+		}
+		return size
+	default:
+		return interfaceOverhead + uint64(reflect.TypeOf(value).Size())
+	}
+}
@@ -0,0 +1,97 @@
+package cache
+
+const (
+	sketchDepth      = 4
+	sketchMaxCount   = 15
+	sketchMinWidth   = 64
+	sketchResetRatio = 10
+)
+
+// countMinSketch estimates how often keys have been seen, using four rows of
+// 4-bit counters packed sixteen to a word. Each key maps to one counter per
+// row, and its estimate is the smallest of them. Once the number of
+// increments reaches ten times the width, every counter is halved, so that
+// old popularity fades away.
+type countMinSketch struct {
+	rows       [sketchDepth][]uint64
+	mask       uint64
+	additions  uint64
+	sampleSize uint64
+}
+
+// newCountMinSketch returns a sketch with at least as many counters per row
+// as the given number of items.
+func newCountMinSketch(items uint64) *countMinSketch {
+	width := uint64(sketchMinWidth)
+	for width < items {
+		width <<= 1
+	}
+
+	s := &countMinSketch{mask: width - 1, sampleSize: sketchResetRatio * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint64, width/16)
+	}
+	return s
+}
+
+func (s *countMinSketch) width() uint64 {
+	return s.mask + 1
+}
+
+// indexes derives the counter of each row from two halves of a 64-bit FNV-1a
+// hash of the key.
+func (s *countMinSketch) indexes(key string) [sketchDepth]uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+
+	first, second := hash, hash>>32|hash<<32|1
+	var indexes [sketchDepth]uint64
+	for i := range indexes {
+		indexes[i] = (first + uint64(i)*second) & s.mask
+	}
+	return indexes
+}
+
+func (s *countMinSketch) counter(row int, index uint64) uint64 {
+	return (s.rows[row][index>>4] >> ((index & 15) << 2)) & 0xf
+}
+
+func (s *countMinSketch) increment(key string) {
+	for row, index := range s.indexes(key) {
+		if s.counter(row, index) < sketchMaxCount {
+			s.rows[row][index>>4] += 1 << ((index & 15) << 2)
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.halve()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint64 {
+	estimate := uint64(sketchMaxCount)
+	for row, index := range s.indexes(key) {
+		if count := s.counter(row, index); count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+// halve divides every counter by two at once, by shifting each word and
+// clearing the bits that moved into the neighbouring counters.
+func (s *countMinSketch) halve() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] = (row[i] >> 1) & 0x7777777777777777
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestSketchNeverUnderestimates(t *testing.T) {
+	s := newCountMinSketch(256)
+	counts := make(map[string]uint64)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprint(random.Intn(500))
+		s.increment(key)
+		counts[key]++
+	}
+
+	for key, count := range counts {
+		if count > sketchMaxCount {
+			count = sketchMaxCount
+		}
+		if estimate := s.estimate(key); estimate < count {
+			t.Errorf("estimated %v for %v; expected at least %v", estimate, key, count)
+		}
+	}
+}
+
+func TestSketchSaturates(t *testing.T) {
+	s := newCountMinSketch(64)
+	for i := 0; i < 100; i++ {
+		s.increment("hot")
+	}
+	if estimate := s.estimate("hot"); estimate != sketchMaxCount {
+		t.Errorf("estimated %v after 100 increments; expected the counters to stop at %v", estimate, sketchMaxCount)
+	}
+	if estimate := s.estimate("cold"); estimate != 0 {
+		t.Errorf("estimated %v for a key never seen; expected 0", estimate)
+	}
+}
+
+func TestSketchHalves(t *testing.T) {
+	s := newCountMinSketch(64)
+	for i := 0; i < 12; i++ {
+		s.increment("hot")
+	}
+	s.halve()
+	if estimate := s.estimate("hot"); estimate != 6 {
+		t.Errorf("estimated %v after halving 12; expected 6", estimate)
+	}
+
+	// Reaching the sample size halves every counter on its own. Saturated
+	// counters have to drop to 7, without their bits spilling into the
+	// neighbouring counters.
+	s = newCountMinSketch(64)
+	for i := 0; i < sketchMaxCount; i++ {
+		s.increment("hot")
+	}
+	for key := 0; s.additions < s.sampleSize-1; key++ {
+		s.increment(fmt.Sprint("cold", key))
+	}
+	if estimate := s.estimate("hot"); estimate != sketchMaxCount {
+		t.Fatalf("estimated %v before the reset; expected %v", estimate, sketchMaxCount)
+	}
+	s.increment("cold")
+	if s.additions != s.sampleSize/2 {
+		t.Errorf("%v additions counted after the reset; expected %v", s.additions, s.sampleSize/2)
+	}
+	if estimate := s.estimate("hot"); estimate != sketchMaxCount/2 {
+		t.Errorf("estimated %v after the reset; expected %v", estimate, sketchMaxCount/2)
+	}
+	for _, row := range s.rows {
+		for _, word := range row {
+			if word&0x8888888888888888 != 0 {
+				t.Fatalf("counter above 7 after the reset: %x", word)
+			}
+		}
+	}
+}
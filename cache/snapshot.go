@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type snapshotItem struct {
+	Key            string      `json:"key"`
+	Value          interface{} `json:"value"`
+	ExpirationTime time.Time   `json:"expirationTime"`
+	Frequency      uint64      `json:"frequency,omitempty"`
+	Tags           []string    `json:"tags,omitempty"`
+}
+
+func (item *snapshotItem) isExpired(now time.Time) bool {
+	return !item.ExpirationTime.IsZero() && now.After(item.ExpirationTime)
+}
+
+// snapshot is the on-disk representation of a cache. Items are stored in
+// the order in which they have to be restored: from least to most recently
+// used for LRU and for each list of ARC and TinyLFU, from oldest to newest
+// for each queue of SIEVE and S3-FIFO, and from most to least frequently
+// used for LFU.
+type snapshot struct {
+	EvictionPolicy EvictionPolicy `json:"evictionPolicy"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	Items          []snapshotItem `json:"items"`
+}
+
+func writeSnapshot(w io.Writer, evictionPolicy EvictionPolicy, items []snapshotItem) error {
+	return json.NewEncoder(w).Encode(snapshot{
+		EvictionPolicy: evictionPolicy,
+		CreatedAt:      time.Now(),
+		Items:          items,
+	})
+}
+
+func readSnapshot(r io.Reader) (*snapshot, error) {
+	var s snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SaveSnapshot atomically replaces the file at path with a snapshot of c.
+func SaveSnapshot(c Cache, path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := c.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// LoadSnapshot replaces the contents of c with the snapshot stored at path.
+func LoadSnapshot(c Cache, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return c.Load(file)
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// savedItems returns the items of the snapshot of c, in the order in which
+// they were saved.
+func savedItems(t *testing.T, c Cache) []snapshotItem {
+	t.Helper()
+	var buffer bytes.Buffer
+	if err := c.Save(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	s, err := readSnapshot(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Items
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, policy := range policies {
+		c, _ := New(10, 0, policy, 0)
+		for i := 0; i < 6; i++ {
+			key := fmt.Sprintf("k%v", i)
+			c.SetItem(Item{Key: key, Value: key, TimeToLive: time.Hour, Tags: []string{"tag"}})
+		}
+		c.Set("expired", "expired", time.Nanosecond)
+		for _, key := range []string{"k1", "k3", "k3"} {
+			c.Get(key)
+		}
+		time.Sleep(time.Millisecond)
+
+		var buffer bytes.Buffer
+		if err := c.Save(&buffer); err != nil {
+			t.Fatal(err)
+		}
+		restored, _ := New(10, 0, policy, 0)
+		if err := restored.Load(&buffer); err != nil {
+			t.Fatalf("%v: %v", policy, err)
+		}
+
+		if _, err := restored.Get("expired"); err == nil {
+			t.Errorf("%v: expired item restored", policy)
+		}
+		originals := make(map[string]snapshotItem)
+		for _, item := range savedItems(t, c) {
+			originals[item.Key] = item
+		}
+		items := savedItems(t, restored)
+		if len(items) != 6 {
+			t.Errorf("%v: %v items restored; expected 6", policy, len(items))
+		}
+		for _, item := range items {
+			original := originals[item.Key]
+			if item.Value != original.Value || !item.ExpirationTime.Equal(original.ExpirationTime) || !reflect.DeepEqual(item.Tags, original.Tags) {
+				t.Errorf("%v: %+v restored; expected %+v", policy, item, original)
+			}
+		}
+		if keys, _ := restored.DeleteTagged("tag"); len(keys) != 6 {
+			t.Errorf("%v: %v tagged items restored; expected 6", policy, len(keys))
+		}
+	}
+}
+
+// TestSnapshotRestoresEvictionState checks that loading a snapshot and saving
+// it again gives back the same items in the same order, which covers the
+// recency order, the LFU frequencies and the SIEVE visited marks.
+func TestSnapshotRestoresEvictionState(t *testing.T) {
+	for _, policy := range policies {
+		c, _ := New(10, 0, policy, 0)
+		for i := 0; i < 6; i++ {
+			key := fmt.Sprintf("k%v", i)
+			c.Set(key, key)
+		}
+		for _, key := range []string{"k1", "k3", "k3", "k0"} {
+			c.Get(key)
+		}
+
+		saved := savedItems(t, c)
+		var buffer bytes.Buffer
+		writeSnapshot(&buffer, policy, saved)
+		restored, _ := New(10, 0, policy, 0)
+		if err := restored.Load(&buffer); err != nil {
+			t.Fatalf("%v: %v", policy, err)
+		}
+		if resaved := savedItems(t, restored); !reflect.DeepEqual(resaved, saved) {
+			t.Errorf("%v: %+v saved after loading; expected %+v", policy, resaved, saved)
+		}
+	}
+}
+
+func TestSnapshotLoadIntoSmallerCache(t *testing.T) {
+	tests := []struct {
+		policy EvictionPolicy
+		keys   []string
+	}{
+		{LRU, []string{"k1", "k3", "k5"}},
+		{LFU, []string{"k1", "k3", "k5"}},
+		{ARC, []string{"k1", "k3", "k5"}},
+		{TinyLFU, []string{"k1", "k3", "k5"}},
+		{SIEVE, []string{"k1", "k3", "k5"}},
+		{S3FIFO, []string{"k3", "k4", "k5"}},
+	}
+	for _, test := range tests {
+		c, _ := New(6, 0, test.policy, 0)
+		for i := 0; i < 6; i++ {
+			key := fmt.Sprintf("k%v", i)
+			c.Set(key, key)
+		}
+		for _, key := range []string{"k1", "k3"} {
+			c.Get(key)
+		}
+
+		var buffer bytes.Buffer
+		if err := c.Save(&buffer); err != nil {
+			t.Fatal(err)
+		}
+		restored, _ := New(3, 0, test.policy, 0)
+		if err := restored.Load(&buffer); err != nil {
+			t.Fatalf("%v: %v", test.policy, err)
+		}
+
+		keys, _, _ := restored.Scan("", "", 10)
+		restoredKeys := make([]string, 0, len(keys))
+		for _, keyInfo := range keys {
+			restoredKeys = append(restoredKeys, keyInfo.Key)
+		}
+		if !reflect.DeepEqual(restoredKeys, test.keys) {
+			t.Errorf("%v: %v restored; expected %v", test.policy, restoredKeys, test.keys)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+type tinyLFURegion uint8
+
+const (
+	windowRegion tinyLFURegion = iota
+	probationRegion
+	protectedRegion
+)
+
+// TinyLFUCache implements W-TinyLFU. New items enter a small LRU window,
+// which holds 1% of the capacity. Items leaving the window become candidates
+// for the main region, and are only admitted if a count-min sketch estimates
+// that they are used more often than the item the main region would evict.
+// The main region is a segmented LRU: items used again while on probation
+// are promoted to the protected segment, which holds 80% of the region.
+type TinyLFUCache struct {
+	cacheInfo
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	items     map[string]*list.Element
+	sketch    *countMinSketch
+}
+
+type tinyLFUItem struct {
+	*cacheItem
+	region tinyLFURegion
+}
+
+func (c *TinyLFUCache) reset() {
+	c.window = &list.List{}
+	c.probation = &list.List{}
+	c.protected = &list.List{}
+	c.items = make(map[string]*list.Element)
+	c.sketch = newCountMinSketch(c.capacity)
+	c.clear()
+}
+
+// regionCapacity is the number of items the regions are sized against. A
+// cache limited only by memory uses the number of items it currently holds.
+func (c *TinyLFUCache) regionCapacity() uint64 {
+	if c.capacity != 0 {
+		return c.capacity
+	}
+	return c.size
+}
+
+func (c *TinyLFUCache) windowCapacity() uint64 {
+	if capacity := c.regionCapacity() / 100; capacity > 1 {
+		return capacity
+	}
+	return 1
+}
+
+func (c *TinyLFUCache) protectedCapacity() uint64 {
+	capacity, windowCapacity := c.regionCapacity(), c.windowCapacity()
+	if capacity <= windowCapacity {
+		return 0
+	}
+	return (capacity - windowCapacity) * 8 / 10
+}
+
+func (c *TinyLFUCache) regionList(region tinyLFURegion) *list.List {
+	switch region {
+	case probationRegion:
+		return c.probation
+	case protectedRegion:
+		return c.protected
+	default:
+		return c.window
+	}
+}
+
+// move puts an item at the front of the given region, and returns its new
+// element.
+func (c *TinyLFUCache) move(listElement *list.Element, region tinyLFURegion) *list.Element {
+	item := listElement.Value.(*tinyLFUItem)
+	c.regionList(item.region).Remove(listElement)
+	item.region = region
+	listElement = c.regionList(region).PushFront(item)
+	c.items[item.key] = listElement
+	return listElement
+}
+
+func (c *TinyLFUCache) Set(key string, value interface{}, timeToLive ...time.Duration) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.set(key, value, nil, timeToLive...)
+}
+
+func (c *TinyLFUCache) SetItem(item Item) (err error) {
+	return c.setItem(c, item)
+}
+
+func (c *TinyLFUCache) SetMany(items []Item) (errs []error) {
+	return c.setMany(c, items)
+}
+
+func (c *TinyLFUCache) set(key string, value interface{}, tags []string, timeToLive ...time.Duration) (err error) {
+	itemSize := estimateSize(key, value) + estimateTagsSize(tags)
+	if !c.fits(itemSize) {
+		return ErrItemTooLarge
+	}
+
+	c.sketch.increment(key)
+
+	var item *tinyLFUItem
+	if listElement, ok := c.items[key]; ok {
+		listElement = c.touch(listElement)
+		item = listElement.Value.(*tinyLFUItem)
+		item.value = value
+		c.memoryUsage = c.memoryUsage - item.size + itemSize
+		item.size = itemSize
+
+		c.evict(0, listElement)
+	} else {
+		item = &tinyLFUItem{cacheItem: &cacheItem{key: key, value: value, size: itemSize}, region: windowRegion}
+		listElement := c.window.PushFront(item)
+		c.items[key] = listElement
+		c.addItem(item.cacheItem)
+
+		// A cache limited only by memory grows its sketch along with the
+		// number of items it holds.
+		if c.capacity == 0 && c.size > c.sketch.width() {
+			c.sketch = newCountMinSketch(2 * c.size)
+		}
+
+		candidates := 0
+		for uint64(c.window.Len()) > c.windowCapacity() {
+			c.move(c.window.Back(), probationRegion)
+			candidates++
+		}
+		c.evict(candidates, listElement)
+	}
+
+	c.updateItem(item.cacheItem, tags, timeToLive...)
+
+	return nil
+}
+
+// touch records a hit: items in the window or in the protected segment move
+// to its front, while items on probation are promoted to the protected
+// segment, which demotes its least recently used items when it overflows.
+func (c *TinyLFUCache) touch(listElement *list.Element) *list.Element {
+	item := listElement.Value.(*tinyLFUItem)
+	switch item.region {
+	case probationRegion:
+		c.move(listElement, protectedRegion)
+		for uint64(c.protected.Len()) > c.protectedCapacity() {
+			c.move(c.protected.Back(), probationRegion)
+		}
+	default:
+		c.regionList(item.region).MoveToFront(listElement)
+	}
+	return c.items[item.key]
+}
+
+// victim returns the item the main region would evict next: the least
+// recently used item on probation, or in the protected segment, or in the
+// window once the main region is empty. The spared element is skipped.
+func (c *TinyLFUCache) victim(spared *list.Element) *list.Element {
+	for _, positionList := range []*list.List{c.probation, c.protected, c.window} {
+		listElement := positionList.Back()
+		if listElement == spared {
+			listElement = listElement.Prev()
+		}
+		if listElement != nil {
+			return listElement
+		}
+	}
+	return nil
+}
+
+// evict removes items until the cache is within its limits. The given number
+// of candidates, which have just left the window for the front of the
+// probation segment, each compete with the victim of the main region, and
+// the one that the sketch estimates to be used less often is evicted.
+func (c *TinyLFUCache) evict(candidates int, spared *list.Element) {
+	var candidate *list.Element
+	if candidates > 0 {
+		candidate = c.probation.Front()
+	}
+
+	for c.isOverLimit() {
+		victim := c.victim(spared)
+		if victim == nil {
+			return
+		}
+
+		evicted := victim
+		if candidate != nil && candidate != victim {
+			if c.frequency(candidate) <= c.frequency(victim) {
+				evicted = candidate
+			}
+
+			candidates--
+			if next := candidate.Next(); candidates > 0 && next != victim {
+				candidate = next
+			} else {
+				candidate = nil
+			}
+		} else if candidate == victim {
+			candidate = nil
+		}
+
+		c.removeCacheItem(evicted, evicted.Value.(*tinyLFUItem).key)
+	}
+}
+
+func (c *TinyLFUCache) frequency(listElement *list.Element) uint64 {
+	return c.sketch.estimate(listElement.Value.(*tinyLFUItem).key)
+}
+
+func (c *TinyLFUCache) Get(key string) (value interface{}, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	item, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.value, nil
+}
+
+func (c *TinyLFUCache) GetItem(key string) (item Item, err error) {
+	return c.getItem(c, key)
+}
+
+func (c *TinyLFUCache) GetMany(keys []string) (items []Item, errs []error) {
+	return c.getMany(c, keys)
+}
+
+// get counts every request in the sketch, including misses, so that keys
+// that are requested often get admitted once they are set.
+func (c *TinyLFUCache) get(key string) (item *cacheItem, err error) {
+	c.sketch.increment(key)
+
+	if listElement, ok := c.items[key]; ok {
+		item := listElement.Value.(*tinyLFUItem)
+
+		if !item.expirationTime.IsZero() && time.Now().After(item.expirationTime) {
+			c.removeCacheItem(listElement, key)
+
+			return nil, fmt.Errorf("item does not exist")
+		}
+
+		c.touch(listElement)
+		item.lastAccess = time.Now()
+
+		return item.cacheItem, nil
+	} else {
+		return nil, fmt.Errorf("item does not exist")
+	}
+}
+
+func (c *TinyLFUCache) Delete(key string) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.delete(c, key)
+}
+
+func (c *TinyLFUCache) DeleteMany(keys []string) (errs []error) {
+	return c.deleteMany(c, keys)
+}
+
+func (c *TinyLFUCache) remove(key string) {
+	if listElement, ok := c.items[key]; ok {
+		c.removeCacheItem(listElement, key)
+	}
+}
+
+func (c *TinyLFUCache) DeleteMatching(pattern *regexp.Regexp) (keys []string, err error) {
+	return c.deleteMatching(c, pattern), nil
+}
+
+func (c *TinyLFUCache) DeleteTagged(tag string) (keys []string, err error) {
+	return c.deleteTagged(c, tag), nil
+}
+
+// Purge removes every item, but keeps the frequencies in the sketch, since
+// they describe the workload rather than the contents of the cache.
+func (c *TinyLFUCache) Purge() (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	sketch := c.sketch
+	c.reset()
+	c.sketch = sketch
+	return nil
+}
+
+func (c *TinyLFUCache) removeCacheItem(listElement *list.Element, key string) {
+	item := listElement.Value.(*tinyLFUItem)
+	c.regionList(item.region).Remove(listElement)
+	c.removeItem(item.cacheItem)
+	delete(c.items, key)
+}
+
+func (c *TinyLFUCache) DeleteExpired(timeInterval time.Duration, stop <-chan struct{}) {
+	c.deleteExpired(c, timeInterval, stop)
+}
+
+func (c *TinyLFUCache) Scan(prefix string, cursor string, limit int) (keys []KeyInfo, nextCursor string, err error) {
+	keys, nextCursor = c.scan(c, prefix, cursor, limit)
+	return keys, nextCursor, nil
+}
+
+func (c *TinyLFUCache) forEachItem(visit func(item *cacheItem)) {
+	for _, listElement := range c.items {
+		visit(listElement.Value.(*tinyLFUItem).cacheItem)
+	}
+}
+
+func (c *TinyLFUCache) keyInfo(key string, now time.Time) (info KeyInfo, ok bool) {
+	listElement, ok := c.items[key]
+	if !ok || listElement.Value.(*tinyLFUItem).isExpired(now) {
+		return KeyInfo{}, false
+	}
+	keyInfo := newKeyInfo(listElement.Value.(*tinyLFUItem).cacheItem, now)
+	keyInfo.Frequency = c.sketch.estimate(key)
+	return keyInfo, true
+}
+
+func (c *TinyLFUCache) Info() (info map[string]interface{}, err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	info = c.info()
+	info["windowSize"] = c.window.Len()
+	info["probationSize"] = c.probation.Len()
+	info["protectedSize"] = c.protected.Len()
+	return info, nil
+}
+
+// Resize also replaces the sketch when the capacity grows beyond its width,
+// which forgets the frequencies seen so far.
+func (c *TinyLFUCache) Resize(capacity uint64, maxMemory uint64) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.capacity = capacity
+	c.maxMemory = maxMemory
+	if capacity > c.sketch.width() {
+		c.sketch = newCountMinSketch(capacity)
+	}
+
+	for uint64(c.window.Len()) > c.windowCapacity() {
+		c.move(c.window.Back(), probationRegion)
+	}
+	for uint64(c.protected.Len()) > c.protectedCapacity() {
+		c.move(c.protected.Back(), probationRegion)
+	}
+	c.evict(0, nil)
+	return nil
+}
+
+// Save stores the probation segment, the protected segment and then the
+// window, each from the least to the most recently used item, along with
+// the frequency estimated by the sketch.
+func (c *TinyLFUCache) Save(w io.Writer) (err error) {
+	c.RLock()
+	items := make([]snapshotItem, 0, c.size)
+	for _, positionList := range []*list.List{c.probation, c.protected, c.window} {
+		for listElement := positionList.Back(); listElement != nil; listElement = listElement.Prev() {
+			item := listElement.Value.(*tinyLFUItem)
+			items = append(items, snapshotItem{
+				Key:            item.key,
+				Value:          item.value,
+				ExpirationTime: item.expirationTime,
+				Frequency:      c.sketch.estimate(item.key),
+				Tags:           item.tags,
+			})
+		}
+	}
+	c.RUnlock()
+
+	return writeSnapshot(w, TinyLFU, items)
+}
+
+// Load restores every item on probation, in the order of the snapshot, and
+// feeds their frequencies back into a new sketch. Items are promoted to the
+// protected segment again once they are used.
+func (c *TinyLFUCache) Load(r io.Reader) (err error) {
+	s, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.reset()
+	c.load(c, s.Items)
+	return nil
+}
+
+func (c *TinyLFUCache) restore(cacheItem *cacheItem, frequency uint64) {
+	for i := uint64(0); i < frequency && i < sketchMaxCount; i++ {
+		c.sketch.increment(cacheItem.key)
+	}
+
+	item := &tinyLFUItem{cacheItem: cacheItem, region: probationRegion}
+	listElement := c.probation.PushFront(item)
+	c.items[item.key] = listElement
+	c.tagItem(item.cacheItem)
+	c.addItem(item.cacheItem)
+	c.evict(0, listElement)
+}
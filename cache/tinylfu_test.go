@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func (c *TinyLFUCache) region(key string) (tinyLFURegion, bool) {
+	listElement, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	return listElement.Value.(*tinyLFUItem).region, true
+}
+
+func TestTinyLFURegions(t *testing.T) {
+	c, _ := New(100, 0, TinyLFU, 0)
+	tinyLFU := c.(*TinyLFUCache)
+
+	tinyLFU.Set("a", 1)
+	if region, _ := tinyLFU.region("a"); region != windowRegion {
+		t.Fatalf("new item in region %v; expected the window", region)
+	}
+
+	// The window holds a single item out of 100, so the next item pushes a
+	// out to probation, where a hit promotes it to the protected segment.
+	tinyLFU.Set("b", 2)
+	if region, _ := tinyLFU.region("a"); region != probationRegion {
+		t.Fatalf("item leaving the window in region %v; expected probation", region)
+	}
+	if region, _ := tinyLFU.region("b"); region != windowRegion {
+		t.Fatalf("new item in region %v; expected the window", region)
+	}
+	tinyLFU.Get("a")
+	if region, _ := tinyLFU.region("a"); region != protectedRegion {
+		t.Fatalf("item read on probation in region %v; expected protected", region)
+	}
+
+	// The protected segment holds 80% of the main region, and demotes its
+	// least recently used items back to probation when it overflows.
+	// Each new key pushes the previous one out of the window, and reading it
+	// then promotes it. The cache never fills up, so nothing is evicted.
+	for i := 0; i < 95; i++ {
+		tinyLFU.Set(fmt.Sprint("key", i), i)
+		if i > 0 {
+			tinyLFU.Get(fmt.Sprint("key", i-1))
+		}
+	}
+	if protected := uint64(tinyLFU.protected.Len()); protected != tinyLFU.protectedCapacity() {
+		t.Errorf("%v protected items; expected the segment to be full with %v", protected, tinyLFU.protectedCapacity())
+	}
+	if region, _ := tinyLFU.region("a"); region != probationRegion {
+		t.Errorf("least recently used protected item in region %v; expected it to be demoted", region)
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	c, _ := New(100, 0, TinyLFU, 0)
+	tinyLFU := c.(*TinyLFUCache)
+
+	for i := 0; i < 100; i++ {
+		tinyLFU.Set(fmt.Sprint("hot", i), i)
+	}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			tinyLFU.Get(fmt.Sprint("hot", i))
+		}
+	}
+
+	// Each one-off key leaves the window for probation, where it loses
+	// against the victim of the main region, which has been read before. The
+	// number of one-off keys stays small enough for the 128 counters of each
+	// row of the sketch not to be saturated by collisions.
+	for i := 0; i < 200; i++ {
+		tinyLFU.Set(fmt.Sprint("once", i), i)
+	}
+
+	evicted := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := tinyLFU.region(fmt.Sprint("hot", i)); !ok {
+			evicted++
+		}
+	}
+	// The window always takes one slot, so one hot key had to make room.
+	if evicted > 1 {
+		t.Errorf("%v hot keys evicted by one-off keys; expected at most 1", evicted)
+	}
+	for i := 0; i < 199; i++ {
+		if _, ok := tinyLFU.region(fmt.Sprint("once", i)); ok {
+			t.Errorf("one-off key once%v admitted into the main region", i)
+		}
+	}
+}
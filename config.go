@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const envPrefix = "ZESTFUL_"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readConfigFile parses a JSON configuration file whose keys are the names of
+// the command-line flags, e.g. {"capacity": 1000, "default-ttl": "1h"}.
+func readConfigFile(path string, flags *flag.FlagSet) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+
+	var contents map[string]interface{}
+	if err := decoder.Decode(&contents); err != nil {
+		return nil, fmt.Errorf("invalid config file %v: %v", path, err)
+	}
+
+	return configValues(contents, flags, "config file "+path)
+}
+
+func configValues(contents map[string]interface{}, flags *flag.FlagSet, source string) (map[string]string, error) {
+	values := make(map[string]string)
+	for key, value := range contents {
+		if key == "config" || flags.Lookup(key) == nil {
+			return nil, fmt.Errorf("unknown key %v in %v", key, source)
+		}
+
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		case bool:
+			values[key] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("invalid value for %v in %v: expected a string or a number", key, source)
+		}
+	}
+
+	return values, nil
+}
+
+// applyConfig fills in every flag that was not given on the command line,
+// first from ZESTFUL_* environment variables and then from the config file.
+// Flags set by neither keep their default values.
+func applyConfig(flags *flag.FlagSet, configPath string) error {
+	setByFlag := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		setByFlag[f.Name] = true
+	})
+
+	if !setByFlag["config"] && configPath == "" {
+		configPath = os.Getenv(envName("config"))
+	}
+
+	fileValues := make(map[string]string)
+	if configPath != "" {
+		var err error
+		fileValues, err = readConfigFile(configPath, flags)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || setByFlag[f.Name] {
+			return
+		}
+
+		source := "environment variable " + envName(f.Name)
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || value == "" {
+			source = "config file"
+			value, ok = fileValues[f.Name]
+		}
+		if !ok {
+			return
+		}
+
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %v (from %v): %v", value, f.Name, source, setErr)
+		}
+	})
+
+	return err
+}
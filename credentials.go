@@ -0,0 +1,30 @@
+package main
+
+import "github.com/infamous55/go-zestful/api"
+
+// scopedSecrets maps the secrets from the options to the scopes they grant.
+// The main secret grants every scope, while the write and read secrets are
+// meant for clients that must not administer the cache. Tokens issued for
+// these secrets use the name of the option as their subject.
+func scopedSecrets(opt options) []api.ScopedSecret {
+	return []api.ScopedSecret{
+		{Name: "secret", Secret: opt.secret, Scopes: []string{api.ScopeItemsRead, api.ScopeItemsWrite, api.ScopeCacheAdmin}},
+		{Name: "write-secret", Secret: opt.writeSecret, Scopes: []string{api.ScopeItemsRead, api.ScopeItemsWrite}},
+		{Name: "read-secret", Secret: opt.readSecret, Scopes: []string{api.ScopeItemsRead}},
+	}
+}
+
+func loadClients(opt options) ([]api.Client, error) {
+	if opt.clientsFile == "" {
+		return nil, nil
+	}
+	return api.ReadClientsFile(opt.clientsFile)
+}
+
+func tokenLifetimes(opt options) api.TokenLifetimes {
+	return api.TokenLifetimes{
+		AccessToken:   opt.tokenTtl,
+		RefreshWindow: opt.tokenRefreshWindow,
+		RefreshToken:  opt.refreshTokenTtl,
+	}
+}
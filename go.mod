@@ -5,3 +5,5 @@ go 1.20
 require github.com/gorilla/mux v1.8.0
 
 require github.com/golang-jwt/jwt/v5 v5.0.0
+
+require golang.org/x/crypto v0.17.0
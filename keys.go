@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/infamous55/go-zestful/api"
+)
+
+// loadSigningKeys returns the keys used to sign and verify tokens, with the
+// active key first. A key given directly takes precedence over the key file.
+// When the key file does not exist yet, a new key is generated and persisted
+// to it; when neither is set, the key only lives as long as the process.
+func loadSigningKeys(opt options) ([]api.SigningKey, error) {
+	if opt.signingKey != "" {
+		return []api.SigningKey{api.NewSigningKey([]byte(opt.signingKey))}, nil
+	}
+
+	if opt.signingKeyFile != "" {
+		keys, err := api.ReadKeyFile(opt.signingKeyFile, opt.signingAlgorithm)
+		if !errors.Is(err, os.ErrNotExist) {
+			return keys, err
+		}
+	}
+
+	key, err := api.GenerateSigningKey(opt.signingAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	keys := []api.SigningKey{key}
+
+	if opt.signingKeyFile != "" {
+		if err := api.WriteKeyFile(opt.signingKeyFile, keys); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// revocationsPath returns the file holding the revoked tokens. Tokens stay
+// valid across restarts as long as their signing keys are persisted, so
+// unless a file is given, revocations are persisted next to the key file.
+func revocationsPath(opt options) string {
+	if opt.revocationsFile == "" && opt.signingKeyFile != "" {
+		return opt.signingKeyFile + ".revocations"
+	}
+	return opt.revocationsFile
+}
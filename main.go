@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -48,110 +52,371 @@ func (p *portNumber) String() string {
 	return fmt.Sprint(*p)
 }
 
-type options struct {
-	capacity       uint64
-	evictionPolicy cache.EvictionPolicy
-	defaultTtl     timeToLive
-	secret         string
-	port           portNumber
+type byteSize uint64
+
+var byteSizeUnits = map[string]uint64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
 }
 
-func parseOptions() options {
-	opt := options{}
+func (b *byteSize) Set(value string) error {
+	value = strings.TrimSpace(value)
+	index := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if index == -1 {
+		index = len(value)
+	}
 
-	flag.Uint64Var(&opt.capacity, "capacity", 0, "set the capacity of the cache")
-	flag.Var(&opt.evictionPolicy, "eviction-policy", "set the eviction policy of the cache (LRU or LFU)")
-	flag.Var(&opt.defaultTtl, "default-ttl", "set the default time-to-live")
-	flag.StringVar(&opt.secret, "secret", "", "set the authorization secret")
-	flag.Var(&opt.port, "port", "set the port number for the web server")
+	number, err := strconv.ParseFloat(value[:index], 64)
+	if err != nil || number < 0 {
+		return fmt.Errorf("parse error")
+	}
+	multiplier, ok := byteSizeUnits[strings.TrimSpace(value[index:])]
+	if !ok {
+		return fmt.Errorf("parse error")
+	}
 
-	flag.Parse()
+	*b = byteSize(number * float64(multiplier))
+	return nil
+}
 
-	envCapacity := os.Getenv("ZESTFUL_CAPACITY")
-	if opt.capacity == 0 && envCapacity != "" {
-		capacity, err := strconv.ParseUint(envCapacity, 10, 64)
-		if err == nil {
-			opt.capacity = capacity
-		}
-	}
+func (b *byteSize) String() string {
+	return fmt.Sprint(uint64(*b))
+}
 
-	envEvictionPolicy := os.Getenv("ZESTFUL_EVICTION_POLICY")
-	if opt.evictionPolicy == "" && (envEvictionPolicy == "LRU" || envEvictionPolicy == "LFU") {
-		opt.evictionPolicy = cache.EvictionPolicy(envEvictionPolicy)
-	}
+type options struct {
+	configPath            string
+	capacity              uint64
+	maxMemory             byteSize
+	evictionPolicy        cache.EvictionPolicy
+	lfuAging              cache.AgingPolicy
+	lfuDecayFactor        float64
+	lfuDecayInterval      time.Duration
+	lfuAgingFactor        float64
+	defaultTtl            timeToLive
+	sweepInterval         time.Duration
+	snapshotFile          string
+	snapshotInterval      time.Duration
+	journalFile           string
+	journalFsync          cache.FsyncPolicy
+	journalRewriteSize    byteSize
+	secret                string
+	writeSecret           string
+	readSecret            string
+	clientsFile           string
+	apiKeysFile           string
+	revocationsFile       string
+	namespacesFile        string
+	tokenTtl              time.Duration
+	tokenRefreshWindow    time.Duration
+	refreshTokenTtl       time.Duration
+	authMaxFailures       uint64
+	authMaxBackoff        time.Duration
+	authGlobalMaxFailures uint64
+	oidcIssuer            string
+	oidcAudience          string
+	oidcKeySet            string
+	oidcScopeClaim        string
+	oidcNamespaceClaim    string
+	signingAlgorithm      api.SigningAlgorithm
+	signingKey            string
+	signingKeyFile        string
+	tlsCert               string
+	tlsKey                string
+	tlsClientCA           string
+	tlsRequireClientCert  bool
+	tlsClientCertAuth     bool
+	port                  portNumber
+}
 
-	envDefaultTtl := os.Getenv("ZESTFUL_DEFAULT_TTL")
-	if !opt.defaultTtl.isSet && envDefaultTtl != "" {
-		defaultTtl, err := time.ParseDuration(envDefaultTtl)
-		if err == nil {
-			opt.defaultTtl.Set(fmt.Sprint(defaultTtl))
-		}
-	}
+func defineFlags(flags *flag.FlagSet, opt *options) {
+	flags.StringVar(&opt.configPath, "config", "", "set the path to a JSON configuration file")
+	flags.Uint64Var(&opt.capacity, "capacity", 0, "set the capacity of the cache")
+	flags.Var(&opt.maxMemory, "max-memory", "set the maximum memory used by cached items (e.g. 512MiB or 2GiB)")
+	flags.Var(&opt.evictionPolicy, "eviction-policy", "set the eviction policy of the cache (LRU, LFU, ARC, TinyLFU, SIEVE or S3-FIFO)")
+	opt.lfuAging = cache.NoAging
+	flags.Var(&opt.lfuAging, "lfu-aging", "set how the LFU policy ages the frequencies of items (none, decay or dynamic)")
+	flags.Float64Var(&opt.lfuDecayFactor, "lfu-decay-factor", 0.5, "set the factor applied to every frequency when they decay")
+	flags.DurationVar(&opt.lfuDecayInterval, "lfu-decay-interval", time.Hour, "set the interval between decays of the frequencies")
+	flags.Float64Var(&opt.lfuAgingFactor, "lfu-aging-factor", 1, "set how far each eviction moves the cache age towards the frequency of the evicted item")
+	flags.Var(&opt.defaultTtl, "default-ttl", "set the default time-to-live")
+	flags.DurationVar(&opt.sweepInterval, "sweep-interval", 5*time.Minute, "set the interval between removals of expired items")
+	flags.StringVar(&opt.snapshotFile, "snapshot-file", "", "set the file used to persist the cache across restarts")
+	flags.DurationVar(&opt.snapshotInterval, "snapshot-interval", 0, "set the interval between snapshots (0 saves only on shutdown)")
+	flags.StringVar(&opt.journalFile, "journal-file", "", "set the file used to log every write to the cache")
+	opt.journalFsync = cache.FsyncEverySecond
+	flags.Var(&opt.journalFsync, "journal-fsync", "set when the journal is flushed to disk (always, everysec or never)")
+	opt.journalRewriteSize = 64 << 20
+	flags.Var(&opt.journalRewriteSize, "journal-rewrite-size", "set the journal size that triggers a compaction")
+	flags.StringVar(&opt.secret, "secret", "", "set the authorization secret")
+	flags.StringVar(&opt.writeSecret, "write-secret", "", "set the secret for tokens that can only read and write items")
+	flags.StringVar(&opt.readSecret, "read-secret", "", "set the secret for tokens that can only read items")
+	flags.StringVar(&opt.clientsFile, "clients-file", "", "set the file holding the clients and their hashed secrets")
+	flags.DurationVar(&opt.tokenTtl, "token-ttl", 20*time.Minute, "set how long access tokens are valid")
+	flags.DurationVar(&opt.tokenRefreshWindow, "token-refresh-window", 2*time.Minute, "set how long before its expiration an access token can be refreshed")
+	flags.DurationVar(&opt.refreshTokenTtl, "refresh-token-ttl", 24*time.Hour, "set how long refresh tokens are valid (0 disables them)")
+	flags.Uint64Var(&opt.authMaxFailures, "auth-max-failures", 5, "set the number of failed token requests from one address before it is slowed down")
+	flags.DurationVar(&opt.authMaxBackoff, "auth-max-backoff", 15*time.Minute, "set the longest time an address has to wait after failed token requests")
+	flags.Uint64Var(&opt.authGlobalMaxFailures, "auth-global-max-failures", 100, "set the number of failed token requests per minute before every failing address is slowed down (0 disables)")
+	flags.StringVar(&opt.apiKeysFile, "api-keys-file", "", "set the file used to persist the API keys (kept in memory if missing)")
+	flags.StringVar(&opt.revocationsFile, "revocations-file", "", "set the file used to persist revoked tokens (next to signing-key-file if missing)")
+	flags.StringVar(&opt.namespacesFile, "namespaces-file", "", "set the file used to persist the namespaces created at runtime (kept in memory if missing)")
+	flags.StringVar(&opt.oidcIssuer, "oidc-issuer", "", "set the issuer of external tokens to accept")
+	flags.StringVar(&opt.oidcAudience, "oidc-audience", "", "set the audience that external tokens must be issued for")
+	flags.StringVar(&opt.oidcKeySet, "oidc-jwks", "", "set the file or URL of the key set of the issuer (discovered if missing)")
+	flags.StringVar(&opt.oidcScopeClaim, "oidc-scope-claim", "scope", "set the claim of external tokens holding their scopes")
+	flags.StringVar(&opt.oidcNamespaceClaim, "oidc-namespace-claim", "namespaces", "set the claim of external tokens holding their namespaces (only the default namespace if missing)")
+	opt.signingAlgorithm = api.HS256
+	flags.Var(&opt.signingAlgorithm, "signing-algorithm", "set the algorithm used to sign tokens (HS256, RS256, ES256 or EdDSA)")
+	flags.StringVar(&opt.signingKey, "signing-key", "", "set the shared secret used to sign tokens with HS256")
+	flags.StringVar(&opt.signingKeyFile, "signing-key-file", "", "set the file holding the keys used to sign tokens, as JSON for HS256 or PEM otherwise (generated if missing)")
+	flags.StringVar(&opt.tlsCert, "tls-cert", "", "set the PEM file holding the certificate of the web server")
+	flags.StringVar(&opt.tlsKey, "tls-key", "", "set the PEM file holding the private key of the web server")
+	flags.StringVar(&opt.tlsClientCA, "tls-client-ca", "", "set the PEM file holding the CAs used to verify client certificates")
+	flags.BoolVar(&opt.tlsRequireClientCert, "tls-require-client-cert", false, "reject connections without a valid client certificate")
+	flags.BoolVar(&opt.tlsClientCertAuth, "tls-client-cert-auth", false, "authenticate requests without a token by the subject of their client certificate")
+	flags.Var(&opt.port, "port", "set the port number for the web server")
+}
 
-	envSecret := os.Getenv("ZESTFUL_SECRET")
-	if opt.secret == "" && envSecret != "" {
-		opt.secret = envSecret
+func (opt *options) validate() error {
+	switch {
+	case opt.capacity == 0 && opt.maxMemory == 0:
+		return fmt.Errorf("missing value for capacity or max-memory: parse error")
+	case opt.evictionPolicy == "":
+		return fmt.Errorf("missing value for eviction-policy: parse error")
+	case !opt.defaultTtl.isSet:
+		return fmt.Errorf("missing value for default-ttl: parse error")
+	case opt.sweepInterval <= 0:
+		return fmt.Errorf("invalid value for sweep-interval: must be positive")
+	case opt.lfuDecayFactor < 0 || opt.lfuDecayFactor >= 1:
+		return fmt.Errorf("invalid value for lfu-decay-factor: must be at least 0 and below 1")
+	case opt.lfuDecayInterval <= 0:
+		return fmt.Errorf("invalid value for lfu-decay-interval: must be positive")
+	case opt.lfuAgingFactor <= 0 || opt.lfuAgingFactor > 1:
+		return fmt.Errorf("invalid value for lfu-aging-factor: must be above 0 and at most 1")
+	case opt.snapshotInterval < 0:
+		return fmt.Errorf("invalid value for snapshot-interval: must not be negative")
+	case (opt.tlsCert == "") != (opt.tlsKey == ""):
+		return fmt.Errorf("missing value for tls-cert or tls-key: both must be set")
+	case opt.tlsClientCA != "" && opt.tlsCert == "":
+		return fmt.Errorf("invalid value for tls-client-ca: requires tls-cert and tls-key")
+	case (opt.tlsRequireClientCert || opt.tlsClientCertAuth) && opt.tlsClientCA == "":
+		return fmt.Errorf("missing value for tls-client-ca: parse error")
+	case opt.tlsClientCertAuth && opt.clientsFile == "":
+		return fmt.Errorf("missing value for clients-file: required by tls-client-cert-auth")
+	case opt.oidcIssuer != "" && opt.oidcAudience == "":
+		return fmt.Errorf("missing value for oidc-audience: required by oidc-issuer")
+	case opt.secret == "" && opt.clientsFile == "" && opt.oidcIssuer == "":
+		return fmt.Errorf("missing value for secret, clients-file or oidc-issuer: parse error")
+	case opt.tokenTtl <= 0:
+		return fmt.Errorf("invalid value for token-ttl: must be positive")
+	case opt.tokenRefreshWindow < 0:
+		return fmt.Errorf("invalid value for token-refresh-window: must not be negative")
+	case opt.refreshTokenTtl < 0:
+		return fmt.Errorf("invalid value for refresh-token-ttl: must not be negative")
+	case opt.authMaxBackoff <= 0:
+		return fmt.Errorf("invalid value for auth-max-backoff: must be positive")
+	case opt.signingKey != "" && !opt.signingAlgorithm.IsSymmetric():
+		return fmt.Errorf("invalid value for signing-key: only supported with HS256")
+	case opt.port == 0:
+		return fmt.Errorf("missing value for port: parse error")
 	}
+	return nil
+}
 
-	envPort := os.Getenv("ZESTFUL_PORT")
-	if opt.port == 0 && envPort != "" {
-		port, err := strconv.ParseUint(envPort, 10, 64)
-		if err == nil && port < 65535 {
-			opt.port = portNumber(port)
-		}
-	}
+func loadOptions(flags *flag.FlagSet, arguments []string) (options, error) {
+	opt := options{}
+	defineFlags(flags, &opt)
 
-	if opt.capacity == 0 || opt.evictionPolicy == "" || opt.port == 0 {
-		flag.Usage()
-		os.Exit(2)
+	if err := flags.Parse(arguments); err != nil {
+		return opt, err
 	}
-	if opt.secret == "" {
-		fmt.Fprint(os.Stderr, "missing value for secret: parse error\n")
-		flag.Usage()
-		os.Exit(2)
+	if err := applyConfig(flags, opt.configPath); err != nil {
+		return opt, err
 	}
-	if !opt.defaultTtl.isSet {
-		fmt.Fprint(os.Stderr, "missing value for default-ttl: parse error\n")
+	return opt, opt.validate()
+}
+
+func parseOptions() options {
+	opt, err := loadOptions(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		flag.Usage()
 		os.Exit(2)
 	}
-
 	return opt
 }
 
 func main() {
 	opt := parseOptions()
 
-	newCache, err := cache.New(opt.capacity, opt.evictionPolicy, opt.defaultTtl.value)
+	logger := log.New(os.Stdout, "", log.Default().Flags())
+	storage := newStorage(opt, logger)
+	newCache, err := storage.open(api.DefaultNamespace, opt.capacity, uint64(opt.maxMemory), opt.evictionPolicy, opt.defaultTtl.value)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v: initialization error\n", err)
 		os.Exit(2)
 	}
-	go newCache.DeleteExpired(5 * time.Minute)
 
-	logger := log.New(os.Stdout, "", log.Default().Flags())
+	namespaces, err := api.NewNamespaces(newCache, opt.namespacesFile, storage.open, storage.discard)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: namespaces error\n", err)
+		os.Exit(2)
+	}
+
 	router := mux.NewRouter()
 	loggingMiddleware := api.GenerateLoggingMiddleware(logger)
 	router.Use(loggingMiddleware)
 
 	itemsRouter := router.PathPrefix("/items").Subrouter()
 	authRouter := router.PathPrefix("/auth").Subrouter()
+	tagsRouter := router.PathPrefix("/tags").Subrouter()
 	cacheRouter := router.PathPrefix("/cache").Subrouter()
-	keyValue := randomString(32)
+	namespaceRouter := router.PathPrefix("/ns/{namespace}").Subrouter()
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	clients, err := loadClients(opt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: clients error\n", err)
+		os.Exit(2)
+	}
+	credentials := api.NewCredentials(scopedSecrets(opt), clients)
+
+	signingKeys, err := loadSigningKeys(opt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: signing key error\n", err)
+		os.Exit(2)
+	}
+	keyring, err := api.NewKeyring(opt.signingAlgorithm, signingKeys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: signing key error\n", err)
+		os.Exit(2)
+	}
+
+	apiKeys, err := api.NewAPIKeys(opt.apiKeysFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: API keys error\n", err)
+		os.Exit(2)
+	}
+
+	denylist, err := api.NewDenylist(revocationsPath(opt))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: revocations error\n", err)
+		os.Exit(2)
+	}
+
+	auth := &api.Auth{
+		Credentials:        credentials,
+		Keyring:            keyring,
+		Denylist:           denylist,
+		APIKeys:            apiKeys,
+		Throttle:           api.NewThrottle(opt.authMaxFailures, opt.authMaxBackoff, opt.authGlobalMaxFailures, logger),
+		RefreshTokens:      api.NewRefreshTokens(),
+		ClientCertificates: opt.tlsClientCertAuth,
+	}
+	auth.SetLifetimes(tokenLifetimes(opt))
+	if opt.oidcIssuer != "" {
+		auth.ExternalIssuer = api.NewExternalIssuer(opt.oidcIssuer, opt.oidcAudience, opt.oidcKeySet, opt.oidcScopeClaim, opt.oidcNamespaceClaim)
+		if err := auth.ExternalIssuer.Refresh(); err != nil {
+			logger.Println("loading the keys of the issuer failed:", err)
+		}
+	}
+	go auth.Denylist.DeleteExpired(opt.sweepInterval)
+	go auth.Throttle.DeleteExpired(opt.sweepInterval)
+	go auth.RefreshTokens.DeleteExpired(opt.sweepInterval)
 
 	api.RegisterItemsHandlers(itemsRouter)
-	authMiddleware := api.GenerateAuthMiddleware([]byte(keyValue))
-	cacheMiddleware := api.GenerateCacheMiddleware(newCache)
+	authMiddleware := api.GenerateAuthMiddleware(auth)
+	namespaceMiddleware := api.GenerateNamespaceMiddleware(namespaces)
 	itemsRouter.Use(authMiddleware)
-	itemsRouter.Use(cacheMiddleware)
+	itemsRouter.Use(namespaceMiddleware)
+
+	api.RegisterTagsHandlers(tagsRouter)
+	tagsRouter.Use(authMiddleware)
+	tagsRouter.Use(namespaceMiddleware)
 
-	api.RegisterAuthHandlers(authRouter, opt.secret, []byte(keyValue))
+	api.RegisterAuthHandlers(authRouter, auth)
 
 	api.RegisterCacheHandlers(cacheRouter)
 	cacheRouter.Use(authMiddleware)
-	cacheRouter.Use(cacheMiddleware)
+	cacheRouter.Use(namespaceMiddleware)
+
+	api.RegisterItemsHandlers(namespaceRouter.PathPrefix("/items").Subrouter())
+	api.RegisterTagsHandlers(namespaceRouter.PathPrefix("/tags").Subrouter())
+	api.RegisterCacheHandlers(namespaceRouter.PathPrefix("/cache").Subrouter())
+	namespaceRouter.Use(authMiddleware)
+	namespaceRouter.Use(namespaceMiddleware)
+
+	config := &runtimeConfig{options: opt, cache: newCache, auth: auth, logger: logger}
+	go config.reloadOnSignal()
+	api.RegisterAdminHandlers(adminRouter, config, auth, namespaces)
+	adminRouter.Use(authMiddleware)
+
+	wellKnownRouter := router.PathPrefix("/.well-known").Subrouter()
+	api.RegisterWellKnownHandlers(wellKnownRouter, auth)
+
+	server := &http.Server{Addr: fmt.Sprintf(":%v", opt.port), Handler: router}
+	go shutdownOnSignal(server, logger)
+
+	if opt.tlsCert != "" {
+		certificates, err := newCertificateReloader(opt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: certificate error\n", err)
+			os.Exit(2)
+		}
+		go certificates.watch(certificateCheckInterval, logger)
+		server.TLSConfig = certificates.tlsConfig()
+	}
 
-	address := fmt.Sprintf(":%v", opt.port)
 	fmt.Printf("started on port %v\n", opt.port)
-	http.ListenAndServe(address, router)
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "%v: server error\n", err)
+		os.Exit(1)
+	}
+
+	if err := storage.close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func saveSnapshots(c cache.Cache, path string, interval time.Duration, stop <-chan struct{}, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := cache.SaveSnapshot(c, path); err != nil {
+			logger.Println("snapshot failed:", err)
+		}
+	}
+}
+
+func shutdownOnSignal(server *http.Server, logger *log.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Println("shutdown failed:", err)
+	}
 }
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/infamous55/go-zestful/api"
+	"github.com/infamous55/go-zestful/cache"
+)
+
+// runtimeConfig applies new options to the running server, either from an
+// admin request or by reloading the configuration on SIGHUP. Like the options
+// themselves, the cache settings only apply to the default namespace.
+type runtimeConfig struct {
+	options options
+	cache   cache.Cache
+	auth    *api.Auth
+	logger  *log.Logger
+	sync.Mutex
+}
+
+func (rc *runtimeConfig) Config() map[string]interface{} {
+	rc.Lock()
+	defer rc.Unlock()
+
+	return map[string]interface{}{
+		"capacity":                rc.options.capacity,
+		"max-memory":              uint64(rc.options.maxMemory),
+		"eviction-policy":         rc.options.evictionPolicy,
+		"lfu-aging":               rc.options.lfuAging,
+		"lfu-decay-factor":        rc.options.lfuDecayFactor,
+		"lfu-decay-interval":      rc.options.lfuDecayInterval.String(),
+		"lfu-aging-factor":        rc.options.lfuAgingFactor,
+		"default-ttl":             rc.options.defaultTtl.String(),
+		"sweep-interval":          rc.options.sweepInterval.String(),
+		"snapshot-file":           rc.options.snapshotFile,
+		"snapshot-interval":       rc.options.snapshotInterval.String(),
+		"journal-file":            rc.options.journalFile,
+		"journal-fsync":           rc.options.journalFsync,
+		"journal-rewrite-size":    uint64(rc.options.journalRewriteSize),
+		"oidc-issuer":             rc.options.oidcIssuer,
+		"oidc-audience":           rc.options.oidcAudience,
+		"oidc-jwks":               rc.options.oidcKeySet,
+		"oidc-scope-claim":        rc.options.oidcScopeClaim,
+		"oidc-namespace-claim":    rc.options.oidcNamespaceClaim,
+		"signing-algorithm":       rc.options.signingAlgorithm,
+		"signing-key-file":        rc.options.signingKeyFile,
+		"clients-file":            rc.options.clientsFile,
+		"api-keys-file":           rc.options.apiKeysFile,
+		"revocations-file":        rc.options.revocationsFile,
+		"namespaces-file":         rc.options.namespacesFile,
+		"tls-cert":                rc.options.tlsCert,
+		"tls-key":                 rc.options.tlsKey,
+		"tls-client-ca":           rc.options.tlsClientCA,
+		"tls-require-client-cert": rc.options.tlsRequireClientCert,
+		"tls-client-cert-auth":    rc.options.tlsClientCertAuth,
+		"port":                    rc.options.port,
+	}
+}
+
+func (rc *runtimeConfig) UpdateConfig(contents map[string]interface{}) error {
+	rc.Lock()
+	defer rc.Unlock()
+
+	requested := options{}
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	defineFlags(flags, &requested)
+
+	values, err := configValues(contents, flags, "request body")
+	if err != nil {
+		return err
+	}
+	next := rc.options
+	for key, value := range values {
+		if err := flags.Set(key, value); err != nil {
+			return fmt.Errorf("invalid value %q for %v: %v", value, key, err)
+		}
+		if err := mergeOption(&next, &requested, key); err != nil {
+			return err
+		}
+	}
+	if err := next.validate(); err != nil {
+		return err
+	}
+
+	return rc.apply(next)
+}
+
+// mergeOption copies the option set by the named flag from requested to next,
+// so that the settings missing from a request keep their current values.
+func mergeOption(next *options, requested *options, name string) error {
+	switch name {
+	case "capacity":
+		next.capacity = requested.capacity
+	case "max-memory":
+		next.maxMemory = requested.maxMemory
+	case "eviction-policy":
+		next.evictionPolicy = requested.evictionPolicy
+	case "lfu-aging":
+		next.lfuAging = requested.lfuAging
+	case "lfu-decay-factor":
+		next.lfuDecayFactor = requested.lfuDecayFactor
+	case "lfu-decay-interval":
+		next.lfuDecayInterval = requested.lfuDecayInterval
+	case "lfu-aging-factor":
+		next.lfuAgingFactor = requested.lfuAgingFactor
+	case "default-ttl":
+		next.defaultTtl = requested.defaultTtl
+	case "sweep-interval":
+		next.sweepInterval = requested.sweepInterval
+	case "snapshot-file":
+		next.snapshotFile = requested.snapshotFile
+	case "snapshot-interval":
+		next.snapshotInterval = requested.snapshotInterval
+	case "journal-file":
+		next.journalFile = requested.journalFile
+	case "journal-fsync":
+		next.journalFsync = requested.journalFsync
+	case "journal-rewrite-size":
+		next.journalRewriteSize = requested.journalRewriteSize
+	case "secret":
+		next.secret = requested.secret
+	case "write-secret":
+		next.writeSecret = requested.writeSecret
+	case "read-secret":
+		next.readSecret = requested.readSecret
+	case "clients-file":
+		next.clientsFile = requested.clientsFile
+	case "token-ttl":
+		next.tokenTtl = requested.tokenTtl
+	case "token-refresh-window":
+		next.tokenRefreshWindow = requested.tokenRefreshWindow
+	case "refresh-token-ttl":
+		next.refreshTokenTtl = requested.refreshTokenTtl
+	case "auth-max-failures":
+		next.authMaxFailures = requested.authMaxFailures
+	case "auth-max-backoff":
+		next.authMaxBackoff = requested.authMaxBackoff
+	case "auth-global-max-failures":
+		next.authGlobalMaxFailures = requested.authGlobalMaxFailures
+	case "api-keys-file":
+		next.apiKeysFile = requested.apiKeysFile
+	case "revocations-file":
+		next.revocationsFile = requested.revocationsFile
+	case "namespaces-file":
+		next.namespacesFile = requested.namespacesFile
+	case "oidc-issuer":
+		next.oidcIssuer = requested.oidcIssuer
+	case "oidc-audience":
+		next.oidcAudience = requested.oidcAudience
+	case "oidc-jwks":
+		next.oidcKeySet = requested.oidcKeySet
+	case "oidc-scope-claim":
+		next.oidcScopeClaim = requested.oidcScopeClaim
+	case "oidc-namespace-claim":
+		next.oidcNamespaceClaim = requested.oidcNamespaceClaim
+	case "signing-algorithm":
+		next.signingAlgorithm = requested.signingAlgorithm
+	case "signing-key":
+		next.signingKey = requested.signingKey
+	case "signing-key-file":
+		next.signingKeyFile = requested.signingKeyFile
+	case "tls-cert":
+		next.tlsCert = requested.tlsCert
+	case "tls-key":
+		next.tlsKey = requested.tlsKey
+	case "tls-client-ca":
+		next.tlsClientCA = requested.tlsClientCA
+	case "tls-require-client-cert":
+		next.tlsRequireClientCert = requested.tlsRequireClientCert
+	case "tls-client-cert-auth":
+		next.tlsClientCertAuth = requested.tlsClientCertAuth
+	case "port":
+		next.port = requested.port
+	default:
+		return fmt.Errorf("%v cannot be changed while the server is running", name)
+	}
+	return nil
+}
+
+func (rc *runtimeConfig) Reload() error {
+	rc.Lock()
+	defer rc.Unlock()
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	next, err := loadOptions(flags, os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	return rc.apply(next)
+}
+
+func (rc *runtimeConfig) apply(next options) error {
+	current := rc.options
+	switch {
+	case next.port != current.port:
+		return fmt.Errorf("port cannot be changed while the server is running")
+	case next.evictionPolicy != current.evictionPolicy:
+		return fmt.Errorf("eviction-policy cannot be changed while the server is running")
+	case next.lfuAging != current.lfuAging || next.lfuDecayFactor != current.lfuDecayFactor ||
+		next.lfuDecayInterval != current.lfuDecayInterval || next.lfuAgingFactor != current.lfuAgingFactor:
+		return fmt.Errorf("lfu-aging, lfu-decay-factor, lfu-decay-interval and lfu-aging-factor cannot be changed while the server is running")
+	case next.sweepInterval != current.sweepInterval:
+		return fmt.Errorf("sweep-interval cannot be changed while the server is running")
+	case next.snapshotFile != current.snapshotFile:
+		return fmt.Errorf("snapshot-file cannot be changed while the server is running")
+	case next.snapshotInterval != current.snapshotInterval:
+		return fmt.Errorf("snapshot-interval cannot be changed while the server is running")
+	case next.journalFile != current.journalFile:
+		return fmt.Errorf("journal-file cannot be changed while the server is running")
+	case next.journalFsync != current.journalFsync:
+		return fmt.Errorf("journal-fsync cannot be changed while the server is running")
+	case next.journalRewriteSize != current.journalRewriteSize:
+		return fmt.Errorf("journal-rewrite-size cannot be changed while the server is running")
+	case next.apiKeysFile != current.apiKeysFile:
+		return fmt.Errorf("api-keys-file cannot be changed while the server is running")
+	case next.revocationsFile != current.revocationsFile:
+		return fmt.Errorf("revocations-file cannot be changed while the server is running")
+	case next.namespacesFile != current.namespacesFile:
+		return fmt.Errorf("namespaces-file cannot be changed while the server is running")
+	case next.oidcIssuer != current.oidcIssuer:
+		return fmt.Errorf("oidc-issuer cannot be changed while the server is running")
+	case next.oidcAudience != current.oidcAudience:
+		return fmt.Errorf("oidc-audience cannot be changed while the server is running")
+	case next.oidcKeySet != current.oidcKeySet:
+		return fmt.Errorf("oidc-jwks cannot be changed while the server is running")
+	case next.oidcScopeClaim != current.oidcScopeClaim:
+		return fmt.Errorf("oidc-scope-claim cannot be changed while the server is running")
+	case next.oidcNamespaceClaim != current.oidcNamespaceClaim:
+		return fmt.Errorf("oidc-namespace-claim cannot be changed while the server is running")
+	case next.tlsCert != current.tlsCert || next.tlsKey != current.tlsKey || next.tlsClientCA != current.tlsClientCA:
+		return fmt.Errorf("tls-cert, tls-key and tls-client-ca cannot be changed while the server is running")
+	case next.tlsRequireClientCert != current.tlsRequireClientCert:
+		return fmt.Errorf("tls-require-client-cert cannot be changed while the server is running")
+	case next.tlsClientCertAuth != current.tlsClientCertAuth:
+		return fmt.Errorf("tls-client-cert-auth cannot be changed while the server is running")
+	}
+
+	// The keys of the issuer are fetched again so that a reload picks up a
+	// rotation, but the identity provider being unreachable must not prevent
+	// the rest of the configuration from being applied. The current keys are
+	// kept in that case.
+	if rc.auth.ExternalIssuer != nil {
+		if err := rc.auth.ExternalIssuer.Refresh(); err != nil {
+			rc.logger.Println("refreshing the keys of the issuer failed:", err)
+		}
+	}
+
+	// Every step that can fail runs before anything is changed, so that a
+	// failed reload leaves the running configuration as it was. The key file
+	// is read again on every reload so that keys can be rotated by editing
+	// it. A generated key is kept unless the key settings change.
+	var keyring *api.Keyring
+	if next.signingKeyFile != "" || next.signingKey != current.signingKey || next.signingAlgorithm != current.signingAlgorithm {
+		signingKeys, err := loadSigningKeys(next)
+		if err != nil {
+			return err
+		}
+		if keyring, err = api.NewKeyring(next.signingAlgorithm, signingKeys); err != nil {
+			return err
+		}
+	}
+
+	clients, err := loadClients(next)
+	if err != nil {
+		return err
+	}
+
+	if keyring != nil {
+		rc.auth.Keyring.Replace(keyring)
+	}
+	if next.secret != current.secret || next.writeSecret != current.writeSecret || next.readSecret != current.readSecret {
+		rc.auth.Credentials.SetSecrets(scopedSecrets(next))
+	}
+	rc.auth.Credentials.SetClients(clients)
+	rc.auth.SetLifetimes(tokenLifetimes(next))
+	rc.auth.Throttle.SetLimits(next.authMaxFailures, next.authMaxBackoff, next.authGlobalMaxFailures)
+
+	// None of the policies fails to resize or to change the default TTL, but
+	// should one do so, the current setting is kept rather than leaving the
+	// rest of the reload half applied.
+	if next.capacity != current.capacity || next.maxMemory != current.maxMemory {
+		if err := rc.cache.Resize(next.capacity, uint64(next.maxMemory)); err != nil {
+			rc.logger.Println("resizing the cache failed:", err)
+			next.capacity, next.maxMemory = current.capacity, current.maxMemory
+		}
+	}
+	if next.defaultTtl.value != current.defaultTtl.value {
+		if err := rc.cache.SetDefaultTtl(next.defaultTtl.value); err != nil {
+			rc.logger.Println("changing the default TTL failed:", err)
+			next.defaultTtl = current.defaultTtl
+		}
+	}
+
+	rc.options = next
+	return nil
+}
+
+func (rc *runtimeConfig) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if err := rc.Reload(); err != nil {
+			rc.logger.Println("reload failed:", err)
+		} else {
+			rc.logger.Println("configuration reloaded")
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/infamous55/go-zestful/api"
+	"github.com/infamous55/go-zestful/cache"
+)
+
+func newTestRuntimeConfig(t *testing.T, issuer *api.ExternalIssuer) *runtimeConfig {
+	t.Helper()
+	opt, err := loadOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{
+		"-capacity", "10", "-eviction-policy", "LRU", "-default-ttl", "1m", "-secret", "secret", "-token-ttl", "5m", "-port", "8080",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cache.New(opt.capacity, uint64(opt.maxMemory), opt.evictionPolicy, opt.defaultTtl.value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &api.Auth{
+		Credentials:    api.NewCredentials(scopedSecrets(opt), nil),
+		Throttle:       api.NewThrottle(opt.authMaxFailures, opt.authMaxBackoff, opt.authGlobalMaxFailures, log.New(io.Discard, "", 0)),
+		ExternalIssuer: issuer,
+	}
+	return &runtimeConfig{options: opt, cache: c, auth: auth, logger: log.New(io.Discard, "", 0)}
+}
+
+func TestMergeOptionCoversEveryFlag(t *testing.T) {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	defineFlags(flags, &options{})
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if err := mergeOption(&options{}, &options{}, f.Name); err != nil {
+			t.Errorf("%v is not merged into the running options: %v", f.Name, err)
+		}
+	})
+}
+
+func TestUpdateConfigKeepsMissingSettings(t *testing.T) {
+	rc := newTestRuntimeConfig(t, nil)
+	opt := rc.options
+
+	if err := rc.UpdateConfig(map[string]interface{}{"capacity": json.Number("20")}); err != nil {
+		t.Fatal(err)
+	}
+
+	next := rc.options
+	if next.capacity != 20 {
+		t.Errorf("capacity = %v; expected 20", next.capacity)
+	}
+	opt.capacity = next.capacity
+	if !reflect.DeepEqual(next, opt) {
+		t.Errorf("settings missing from the request changed: %+v; expected %+v", next, opt)
+	}
+}
+
+func TestReloadWithUnreachableIssuer(t *testing.T) {
+	issuer := api.NewExternalIssuer("https://issuer.invalid", "audience", "file://"+filepath.Join(t.TempDir(), "missing.json"), "", "")
+	rc := newTestRuntimeConfig(t, issuer)
+
+	if err := rc.UpdateConfig(map[string]interface{}{"capacity": json.Number("20")}); err != nil {
+		t.Fatalf("reload failed because of the issuer: %v", err)
+	}
+	if rc.options.capacity != 20 {
+		t.Errorf("capacity = %v; expected 20", rc.options.capacity)
+	}
+}
+
+func TestFailedReloadChangesNothing(t *testing.T) {
+	directory := t.TempDir()
+	invalidKeyFile := filepath.Join(directory, "keys.pem")
+	if err := os.WriteFile(invalidKeyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, invalidSetting := range map[string]map[string]interface{}{
+		"missing clients file": {"clients-file": filepath.Join(directory, "missing.json")},
+		"invalid signing key":  {"signing-key-file": invalidKeyFile},
+	} {
+		rc := newTestRuntimeConfig(t, nil)
+		opt := rc.options
+
+		invalidSetting["capacity"] = json.Number("20")
+		invalidSetting["default-ttl"] = "5m"
+		if err := rc.UpdateConfig(invalidSetting); err == nil {
+			t.Fatalf("%v: reload succeeded", name)
+		}
+
+		if !reflect.DeepEqual(rc.options, opt) {
+			t.Errorf("%v: options changed by a failed reload: %+v; expected %+v", name, rc.options, opt)
+		}
+		info, _ := rc.cache.Info()
+		if info["capacity"] != opt.capacity || info["defaultTtl"] != opt.defaultTtl.value {
+			t.Errorf("%v: cache changed by a failed reload: %v", name, info)
+		}
+	}
+}
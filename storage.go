@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/infamous55/go-zestful/api"
+	"github.com/infamous55/go-zestful/cache"
+)
+
+// storage opens the cache of every namespace with the persistence settings
+// from the options. Each namespace other than the default one gets its own
+// snapshot and journal files, named after the configured ones with the name
+// of the namespace appended.
+type storage struct {
+	options options
+	logger  *log.Logger
+	caches  map[string]*storedCache
+	sync.Mutex
+}
+
+// storedCache holds what storage opened for a namespace: its cache, the
+// journal wrapping it if any, and the channel stopping its background tasks.
+type storedCache struct {
+	cache   cache.Cache
+	journal *cache.JournaledCache
+	stop    chan struct{}
+}
+
+func newStorage(opt options, logger *log.Logger) *storage {
+	return &storage{options: opt, logger: logger, caches: make(map[string]*storedCache)}
+}
+
+func namespacePath(path string, namespace string) string {
+	if path == "" || namespace == api.DefaultNamespace {
+		return path
+	}
+	return path + "." + namespace
+}
+
+// open creates the cache of the namespace and restores it from its snapshot
+// and journal. The background tasks of the cache are only started once every
+// step that can fail has succeeded, and run until the storage is closed.
+func (s *storage) open(name string, capacity uint64, maxMemory uint64, evictionPolicy cache.EvictionPolicy, defaultTtl time.Duration) (cache.Cache, error) {
+	newCache, err := cache.New(capacity, maxMemory, evictionPolicy, defaultTtl)
+	if err != nil {
+		return nil, err
+	}
+
+	lfuCache, _ := newCache.(*cache.LFUCache)
+	if lfuCache != nil {
+		factor := s.options.lfuAgingFactor
+		if s.options.lfuAging == cache.DecayAging {
+			factor = s.options.lfuDecayFactor
+		}
+		if err := lfuCache.SetAging(s.options.lfuAging, factor); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshotFile := namespacePath(s.options.snapshotFile, name)
+	if snapshotFile != "" {
+		err := cache.LoadSnapshot(newCache, snapshotFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("snapshot %v: %v", snapshotFile, err)
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	stored := &storedCache{stop: make(chan struct{})}
+	if journalFile := namespacePath(s.options.journalFile, name); journalFile != "" {
+		journal, err := cache.OpenJournal(newCache, journalFile, s.options.journalFsync, uint64(s.options.journalRewriteSize), defaultTtl)
+		if err != nil {
+			return nil, fmt.Errorf("journal %v: %v", journalFile, err)
+		}
+		stored.journal = journal
+		newCache = journal
+	}
+	stored.cache = newCache
+
+	go newCache.DeleteExpired(s.options.sweepInterval, stored.stop)
+	if lfuCache != nil && s.options.lfuAging == cache.DecayAging {
+		go lfuCache.DecayFrequencies(s.options.lfuDecayInterval, stored.stop)
+	}
+	if snapshotFile != "" && s.options.snapshotInterval != 0 {
+		go saveSnapshots(newCache, snapshotFile, s.options.snapshotInterval, stored.stop, s.logger)
+	}
+	s.caches[name] = stored
+	return newCache, nil
+}
+
+// discard stops the background tasks of the cache of the namespace and closes
+// its journal, without saving a snapshot, for a cache that was opened but
+// never served. Its files are left as they were before it was opened.
+func (s *storage) discard(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	stored, ok := s.caches[name]
+	if !ok {
+		return nil
+	}
+	delete(s.caches, name)
+
+	close(stored.stop)
+	if stored.journal != nil {
+		if err := stored.journal.Close(); err != nil {
+			return fmt.Errorf("%v: journal error", err)
+		}
+	}
+	return nil
+}
+
+// close stops the background tasks, saves a snapshot of every cache and
+// closes the journals, and returns the first error it meets.
+func (s *storage) close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, stored := range s.caches {
+		close(stored.stop)
+	}
+
+	var firstErr error
+	if s.options.snapshotFile != "" {
+		for name, stored := range s.caches {
+			if err := cache.SaveSnapshot(stored.cache, namespacePath(s.options.snapshotFile, name)); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("%v: snapshot error", err)
+			}
+		}
+	}
+	for _, stored := range s.caches {
+		if stored.journal == nil {
+			continue
+		}
+		if err := stored.journal.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%v: journal error", err)
+		}
+	}
+	return firstErr
+}
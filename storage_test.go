@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infamous55/go-zestful/api"
+	"github.com/infamous55/go-zestful/cache"
+)
+
+func newTestStorage(t *testing.T) *storage {
+	directory := t.TempDir()
+	opt := options{
+		sweepInterval:      time.Minute,
+		lfuAging:           cache.DecayAging,
+		lfuDecayFactor:     0.5,
+		lfuDecayInterval:   time.Minute,
+		snapshotFile:       filepath.Join(directory, "snapshot"),
+		snapshotInterval:   time.Minute,
+		journalFile:        filepath.Join(directory, "journal"),
+		journalFsync:       cache.FsyncNever,
+		journalRewriteSize: 1 << 20,
+	}
+	return newStorage(opt, log.New(io.Discard, "", 0))
+}
+
+// waitForGoroutines waits for the number of goroutines to drop back to the
+// expected one, since stopped goroutines take a moment to return.
+func waitForGoroutines(t *testing.T, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > expected && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if count := runtime.NumGoroutine(); count > expected {
+		t.Errorf("%v goroutines left running; expected %v", count, expected)
+	}
+}
+
+func TestStorageOpenFailureStartsNoGoroutines(t *testing.T) {
+	s := newTestStorage(t)
+	if err := os.WriteFile(s.options.snapshotFile, []byte("corrupt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	if _, err := s.open(api.DefaultNamespace, 10, 0, cache.LFU, 0); err == nil {
+		t.Fatal("opened a cache with a corrupt snapshot")
+	}
+	waitForGoroutines(t, before)
+}
+
+func TestStorageRejectsTruncatedSnapshot(t *testing.T) {
+	s := newTestStorage(t)
+	snapshotFile := namespacePath(s.options.snapshotFile, api.DefaultNamespace)
+
+	c, _ := cache.New(10, 0, cache.LRU, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, key)
+	}
+	if err := cache.SaveSnapshot(c, snapshotFile); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(snapshotFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := contents[:len(contents)/2]
+	if err := os.WriteFile(snapshotFile, truncated, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.open(api.DefaultNamespace, 10, 0, cache.LRU, 0)
+	if err == nil || !strings.Contains(err.Error(), snapshotFile) {
+		t.Fatalf("opening a cache with a truncated snapshot returned %v; expected an error naming the file", err)
+	}
+	if kept, _ := os.ReadFile(snapshotFile); string(kept) != string(truncated) {
+		t.Error("truncated snapshot overwritten")
+	}
+}
+
+func TestStorageCloseStopsGoroutines(t *testing.T) {
+	s := newTestStorage(t)
+
+	before := runtime.NumGoroutine()
+	for _, name := range []string{api.DefaultNamespace, "other"} {
+		if _, err := s.open(name, 10, 0, cache.LFU, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runtime.NumGoroutine() == before {
+		t.Fatal("no background tasks started")
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	waitForGoroutines(t, before)
+}
+
+func TestStorageDiscardStopsGoroutines(t *testing.T) {
+	s := newTestStorage(t)
+	if _, err := s.open(api.DefaultNamespace, 10, 0, cache.LFU, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	if _, err := s.open("other", 10, 0, cache.LFU, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.discard("other"); err != nil {
+		t.Fatal(err)
+	}
+	waitForGoroutines(t, before)
+	if _, err := os.Stat(namespacePath(s.options.snapshotFile, "other")); !os.IsNotExist(err) {
+		t.Errorf("snapshot of a discarded cache saved: %v", err)
+	}
+
+	if _, err := s.open("other", 10, 0, cache.LFU, 0); err != nil {
+		t.Fatalf("reopening a discarded namespace: %v", err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const certificateCheckInterval = 10 * time.Second
+
+// certificateReloader serves the certificate and the client CA bundle from
+// their files, and reads them again whenever one of the files changes, so
+// that renewed certificates are picked up without a restart.
+type certificateReloader struct {
+	certFile          string
+	keyFile           string
+	clientCAFile      string
+	requireClientCert bool
+
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    map[string]time.Time
+	sync.RWMutex
+}
+
+func newCertificateReloader(opt options) (*certificateReloader, error) {
+	cr := &certificateReloader{
+		certFile:          opt.tlsCert,
+		keyFile:           opt.tlsKey,
+		clientCAFile:      opt.tlsClientCA,
+		requireClientCert: opt.tlsRequireClientCert,
+	}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certificateReloader) files() []string {
+	files := []string{cr.certFile, cr.keyFile}
+	if cr.clientCAFile != "" {
+		files = append(files, cr.clientCAFile)
+	}
+	return files
+}
+
+func (cr *certificateReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range cr.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	certificate, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if cr.clientCAFile != "" {
+		contents, err := os.ReadFile(cr.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(contents) {
+			return fmt.Errorf("invalid client CA file %v: no certificates", cr.clientCAFile)
+		}
+	}
+
+	cr.Lock()
+	defer cr.Unlock()
+
+	cr.certificate = &certificate
+	cr.clientCAs = clientCAs
+	cr.modTimes = modTimes
+	return nil
+}
+
+func (cr *certificateReloader) changed() bool {
+	cr.RLock()
+	defer cr.RUnlock()
+
+	for _, file := range cr.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(cr.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch checks the files periodically. A certificate that cannot be loaded,
+// e.g. because only one of the files has been replaced so far, is retried
+// on the next check while the previous one keeps being served.
+func (cr *certificateReloader) watch(interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		if !cr.changed() {
+			continue
+		}
+		if err := cr.reload(); err != nil {
+			logger.Println("reloading the certificate failed:", err)
+		} else {
+			logger.Println("certificate reloaded")
+		}
+	}
+}
+
+// tlsConfig returns the configuration of the server. The configuration
+// returned for each handshake replaces it entirely, so it repeats the
+// protocols offered through ALPN, without which HTTP/2 would be disabled.
+func (cr *certificateReloader) tlsConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cr.RLock()
+		defer cr.RUnlock()
+
+		config := &tls.Config{
+			MinVersion:   base.MinVersion,
+			NextProtos:   base.NextProtos,
+			Certificates: []tls.Certificate{*cr.certificate},
+		}
+		if cr.clientCAs != nil {
+			config.ClientCAs = cr.clientCAs
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+			if cr.requireClientCert {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+		return config, nil
+	}
+	return base
+}